@@ -0,0 +1,198 @@
+// Package diff produces unified diffs between two texts, as `diff -u` does.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// op is an edit operation on a line.
+type op struct {
+	kind byte // ' ' (keep), '-' (delete) or '+' (insert)
+	line string
+}
+
+// Unified returns a unified diff between two texts, with a number of lines of context around changes. The names
+// label the texts in the header. When the texts are equal, the diff is "".
+func Unified(oldName, newName, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := edits(splitLines(oldText), splitLines(newText))
+
+	out := fmt.Sprintf("--- %v\n+++ %v\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change, a hunk starts with context lines before it.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		hunkStart := first - context
+		if hunkStart < start {
+			hunkStart = start
+		}
+
+		// Extend the hunk until the context after a change doesn't reach the next change.
+		end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+		hunkEnd := end + context
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		// Line numbers in the header count from 1, and are the line before the hunk when it has no lines.
+		oldStart, newStart := 1, 1
+		for _, o := range ops[:hunkStart] {
+			if o.kind != '+' {
+				oldStart++
+			}
+			if o.kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, o := range ops[hunkStart:hunkEnd] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+			body.WriteByte(o.kind)
+			body.WriteString(o.line)
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		out += fmt.Sprintf("@@ -%v,%v +%v,%v @@\n", oldStart, oldCount, newStart, newCount) + body.String()
+		start = hunkEnd
+	}
+	return out
+}
+
+// splitLines splits a text into lines which keep their newline. A last line without a newline is marked as such.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+// edits returns a shortest list of operations that changes a into b, using the linear space variant of Myers'
+// algorithm: the middle snake of a shortest edit path splits the texts, and both halves are diffed in turn. This keeps
+// memory proportional to the size of the texts, however much they differ.
+func edits(a, b []string) []op {
+	var ops []op
+	diffRange(a, b, &ops)
+	return ops
+}
+
+// diffRange appends the operations that change a into b.
+func diffRange(a, b []string, ops *[]op) {
+	// Lines that both start with are kept, as are the lines that they both end with.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	post := 0
+	for post < len(a)-pre && post < len(b)-pre && a[len(a)-1-post] == b[len(b)-1-post] {
+		post++
+	}
+	for _, line := range a[:pre] {
+		*ops = append(*ops, op{' ', line})
+	}
+	mid, tail := a[pre:len(a)-post], a[len(a)-post:]
+	a, b = mid, b[pre:len(b)-post]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			*ops = append(*ops, op{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			*ops = append(*ops, op{'-', line})
+		}
+	default:
+		x, y, u, v := middleSnake(a, b)
+		diffRange(a[:x], b[:y], ops)
+		for _, line := range a[x:u] {
+			*ops = append(*ops, op{' ', line})
+		}
+		diffRange(a[u:], b[v:], ops)
+	}
+
+	for _, line := range tail {
+		*ops = append(*ops, op{' ', line})
+	}
+}
+
+// middleSnake returns the middle snake of a shortest edit path from a to b, as the start (x, y) and end (u, v) of a
+// run of equal lines. Paths are searched from both ends at once, until they overlap. Both texts must be non-empty.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+	// vf[offset+k] is the furthest x on diagonal k of the forward paths, vb[offset+k] the same for the backward paths,
+	// which run over the reversed texts. Diagonal k of the forward paths is diagonal delta-k of the backward ones.
+	vf := make([]int, 2*offset+1)
+	vb := make([]int, 2*offset+1)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if kb := delta - k; odd && kb >= -(d-1) && kb <= d-1 && x+vb[offset+kb] >= n {
+				return x0, y0, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+			if kf := delta - k; !odd && kf >= -d && kf <= d && x+vf[offset+kf] >= n {
+				return n - x, m - y, n - x0, m - y0
+			}
+		}
+	}
+	// The paths overlap at the latest when half of the edits are done from both ends.
+	panic("diff: no middle snake")
+}
@@ -0,0 +1,116 @@
+package diff
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	for _, test := range []struct {
+		oldText string
+		newText string
+		context int
+		want    string
+	}{
+		{
+			oldText: "a\nb\nc\n",
+			newText: "a\nb\nc\n",
+			context: 3,
+			want:    "",
+		},
+		{
+			oldText: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n",
+			newText: "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\n",
+			context: 3,
+			want: "--- old\n+++ new\n" +
+				"@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			// Two changes that are far apart make two hunks.
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			newText: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			context: 1,
+			want: "--- old\n+++ new\n" +
+				"@@ -1,1 +1,2 @@\n+0\n 1\n" +
+				"@@ -11,2 +12,1 @@\n 11\n-12\n",
+		},
+		{
+			oldText: "",
+			newText: "a\n",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n",
+		},
+		{
+			oldText: "a\nb\n",
+			newText: "",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			oldText: "a\n",
+			newText: "a",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1,1 +1,1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+	} {
+		if got := Unified("old", "new", test.oldText, test.newText, test.context); got != test.want {
+			t.Errorf("Unified(%q, %q, %v) = %q, want %q", test.oldText, test.newText, test.context, got, test.want)
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	// Large texts, including ones that a new output file is compared to, are diffed without keeping a copy of all
+	// paths for each step.
+	var lines []string
+	for i := 0; i < 8000; i++ {
+		lines = append(lines, fmt.Sprintf("line %v\n", i))
+	}
+	changed := append([]string(nil), lines...)
+	for i := 0; i < len(changed); i += 100 {
+		changed[i] = "changed\n"
+	}
+	for _, test := range []struct {
+		a, b []string
+	}{
+		{a: nil, b: lines},
+		{a: lines, b: nil},
+		{a: lines, b: changed},
+		{a: lines[:10], b: lines[5:]},
+	} {
+		ops := edits(test.a, test.b)
+		var a, b []string
+		for _, o := range ops {
+			if o.kind != '+' {
+				a = append(a, o.line)
+			}
+			if o.kind != '-' {
+				b = append(b, o.line)
+			}
+		}
+		if strings.Join(a, "") != strings.Join(test.a, "") || strings.Join(b, "") != strings.Join(test.b, "") {
+			t.Errorf("edits(%v lines, %v lines) don't change the one into the other", len(test.a), len(test.b))
+		}
+	}
+}
+
+func TestEditsMemory(t *testing.T) {
+	// Two entirely different texts need as many edits as they have lines, that may not take memory for each edit.
+	var a, b []string
+	for i := 0; i < 10000; i++ {
+		a = append(a, fmt.Sprintf("old %v\n", i))
+		b = append(b, fmt.Sprintf("new %v\n", i))
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ops := edits(a, b)
+	runtime.ReadMemStats(&after)
+	if len(ops) != len(a)+len(b) {
+		t.Errorf("edits(%v lines, %v other lines) = %v operations, want %v", len(a), len(b), len(ops), len(a)+len(b))
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("edits(%v lines, %v other lines) allocates %v bytes, want at most %v", len(a), len(b), alloc, 64<<20)
+	}
+}
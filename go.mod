@@ -2,4 +2,7 @@ module github.com/KarelKubat/gtpl
 
 go 1.20
 
-require github.com/KarelKubat/flagnames v1.0.0 // indirect
+require (
+	github.com/KarelKubat/flagnames v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/KarelKubat/flagnames"
 	"github.com/KarelKubat/gtpl/logger"
+	"github.com/KarelKubat/gtpl/manifest"
 	"github.com/KarelKubat/gtpl/processor"
+	"github.com/KarelKubat/gtpl/server"
+	"github.com/KarelKubat/gtpl/syringe"
 )
 
 const (
 	usageInfo = `
 Welcome to gtpl, the Generic (Go-style) Template Expander.
 Usage: gtpl [FLAGS] FILE [FILE...]
+       gtpl [FLAGS] serve [-listen ADDRESS] -templates DIR
+       gtpl [FLAGS] repl
 
 All files are scanned and executed as one template. File - (one hyphen) makes
 gtpl read from stdin (you'll need a -- as flag terminator).
@@ -22,6 +32,7 @@ The below flags can be abbreviated
 to a unique selector (-l can mean two things, -le is unique, -b is fine too).
 
 `
+	watchInterval = time.Second // How often -watch checks for changes
 )
 
 var (
@@ -32,10 +43,75 @@ var (
 	builtinsFlag     = flag.Bool("builtins", false, "when true, list built in functions and stop")
 	removeEmptyLines = flag.Bool("remove-empty-lines", false, "when true, remove empty lines from the output")
 	listTemplate     = flag.Bool("list-template", false, "list template with line numbers on stdout before processing")
+	outputFile       = flag.String("o", "", "output file, written only when processing succeeds and the content changes, stdout when unset")
+	checkFlag        = flag.Bool("check", false, "when true, don't write the -o file but fail when it's not up to date")
+	diffFlag         = flag.Bool("diff", false, "when true, don't write the -o file but show how it would change")
+	watchFlag        = flag.Bool("watch", false, "when true, keep running and process again when inputs change")
+	outputDir        = flag.String("destdir", "", `directory for files written using "file", the current directory when unset`)
+	treeDir          = flag.String("tree", "", "directory tree to render into -destdir, FILEs are then preludes for each template")
+	manifestFile     = flag.String("manifest", "", "YAML file listing jobs to run concurrently, instead of processing FILEs")
+	eachFlag         = flag.Bool("each", false, "when true, process each FILE with the -prelude files into its own output, see -pattern")
+	outPattern       = flag.String("pattern", "", `output file for each FILE with -each, e.g. "{{ base }}.conf", may use base, name and dir`)
+	managedBlock     = flag.String("managed-block", "", `when set, replace only the lines between "# BEGIN gtpl:NAME" and "# END gtpl:NAME" in -o`)
+	managedComment   = flag.String("managed-comment", "#", "start of the marker lines of -managed-block")
+	keepGoing        = flag.Bool("keep-going", false, `when true, "assert" and "die" don't stop, all failures are reported at the end`)
+	sandbox          = flag.Bool("sandbox", false, `when true, deny builtins with side effects ("env", "log", "include", "file" etc.), see -permit`)
+	timeout          = flag.Duration("timeout", 0, `maximum time to execute a template, e.g. "10s", no limit when 0`)
+	maxOutput        = flag.Int("max-output", 0, "maximum number of bytes of output of a template, no limit when 0")
+	maxLoop          = flag.Int("max-loop", 0, `maximum number of iterations of one "loop", no limit when 0`)
+	debug            = flag.Bool("debug", false, `when true, "breakpoint" pauses and opens an inspector on the terminal`)
+	profile          = flag.Bool("profile", false, "when true, report the time of templates, builtins and lines on stderr at the end")
+	profileOutput    = flag.String("pprof", "", "file to write a profile to in the format of pprof, also when -profile is false")
+	trace            = flag.Bool("trace", false, `when true, log every call of a builtin or template with its position, arguments and result`)
+	strict           = flag.Bool("strict", false, `when true, missing keys (also for "getval") and unset variables ("env") are errors`)
+	dataFiles        stringList
+	values           stringList
+	includePath      stringList
+	preludes         stringList
+	permits          capabilityList
 )
 
+// stringList is a flag.Value for flags that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// capabilityList is a flag.Value for -permit, which may be repeated and takes comma-separated capabilities.
+type capabilityList []syringe.Capability
+
+func (l *capabilityList) String() string {
+	var names []string
+	for _, c := range *l {
+		names = append(names, string(c))
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *capabilityList) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		c, err := syringe.ParseCapability(name)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, c)
+	}
+	return nil
+}
+
 func main() {
 	// Parse the commandline.
+	flag.Var(&dataFiles, "data", `JSON or YAML file exposed as .Data, "NAME=FILE" exposes it as .Data.NAME, may be repeated`)
+	flag.Var(&values, "set", `sets a value in .Data, "key.path=value", e.g. "hosts.0.port=2222", may be repeated`)
+	flag.Var(&includePath, "I", `directory to search for included files, before $GTPL_PATH, may be repeated`)
+	flag.Var(&preludes, "prelude", `file that precedes the FILEs, e.g. with common settings, may be repeated`)
+	flag.Var(&permits, "permit", `capabilities that -sandbox allows: "env", "log", "read" (include) or "write" (file), may be repeated`)
 	flagnames.Patch()
 	usage := func() {
 		fmt.Fprint(flag.CommandLine.Output(), usageInfo)
@@ -51,14 +127,39 @@ func main() {
 	check(err)
 
 	// Instantiate the processor.
-	p := processor.New(&processor.Opts{
+	opts := processor.Opts{
 		AllowAliases:     *allowAliases,
 		LeftDelimiter:    *leftDelimiter,
 		RightDelimter:    *rightDelimiter,
 		RemoveEmptyLines: *removeEmptyLines,
 		ListTemplate:     *listTemplate,
 		Logger:           l,
-	})
+		DataFiles:        dataFiles,
+		Values:           values,
+		IncludePath:      includePath,
+		OutputDir:        *outputDir,
+		ManagedBlock:     *managedBlock,
+		ManagedComment:   *managedComment,
+		KeepGoing:        *keepGoing,
+		Strict:           *strict,
+		Sandbox:          *sandbox,
+		Permit:           permits,
+		Trace:            *trace,
+		Profile:          *profile || *profileOutput != "",
+		Limits: processor.Limits{
+			Timeout:   *timeout,
+			MaxOutput: *maxOutput,
+			MaxLoop:   *maxLoop,
+		},
+	}
+	if *debug {
+		// The inspector uses the terminal, so that the template and the output may still be stdin and stdout.
+		opts.Debug = true
+		if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+			opts.DebugInput, opts.DebugOutput = tty, tty
+		}
+	}
+	p := processor.New(&opts)
 
 	// Show a short overview of builtins and stop, if requested.
 	if *builtinsFlag {
@@ -66,14 +167,135 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Serve templates over HTTP if requested, the flags apply to all requests.
+	if flag.Arg(0) == "serve" {
+		check(serve(p, flag.Args()[1:]))
+		os.Exit(0)
+	}
+
+	// Start an interactive session if requested, the flags apply to it.
+	if flag.Arg(0) == "repl" {
+		prompt := ""
+		if st, err := os.Stdin.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+			prompt = "gtpl> "
+		}
+		check(p.REPL(os.Stdin, os.Stdout, prompt))
+		os.Exit(0)
+	}
+
+	// Run the jobs of a manifest if requested, the flags provide defaults.
+	if *manifestFile != "" {
+		m, err := manifest.Load(*manifestFile)
+		check(err)
+		opts.ProfileTo = p.Profile()
+		failed := 0
+		for _, r := range m.Run(opts) {
+			fmt.Println(r)
+			if r.Err != nil {
+				failed++
+			}
+		}
+		check(writeProfile(p))
+		if failed > 0 {
+			check(fmt.Errorf("%v of %v jobs failed", failed, len(m.Jobs)))
+		}
+		os.Exit(0)
+	}
+
+	// Render a directory tree if requested, positional arguments are optional preludes.
+	if *treeDir != "" {
+		if *outputDir == "" {
+			check(errors.New("-tree needs an output directory (-destdir)"))
+		}
+		check(p.ProcessTree(append(preludes, flag.Args()...), *treeDir, *outputDir))
+		check(writeProfile(p))
+		os.Exit(0)
+	}
+
 	// At this point we want to process some files. We need at least 1 positional argument.
 	if flag.NArg() < 1 {
 		usage()
 	}
 
-	// All ready.
-	check(p.ProcessFiles(flag.Args(), os.Stdout))
+	// All ready. Without -each, the preludes simply precede the positional arguments.
+	if *eachFlag && *outPattern == "" {
+		check(errors.New("-each needs an output pattern (-pattern)"))
+	}
+	files := append(preludes, flag.Args()...)
+	render := func() error {
+		switch {
+		case *eachFlag:
+			return p.ProcessEach(preludes, flag.Args(), *outPattern)
+		case *outputFile != "":
+			return p.ProcessToFile(files, *outputFile)
+		default:
+			return p.ProcessFiles(files, os.Stdout)
+		}
+	}
+	switch {
+	case *checkFlag || *diffFlag:
+		// Checking or diffing compares against the output file (-o or from front matter) without writing it.
+		d, err := p.Compare(files, *outputFile)
+		check(err)
+		if *diffFlag {
+			fmt.Print(d)
+		}
+		if *checkFlag && d != "" {
+			check(errors.New("output is not up to date, -diff shows the changes"))
+		}
+	case *watchFlag:
+		// Watching re-renders upon changes, errors are shown but don't stop.
+		check(p.Watch(context.Background(), watchInterval, render, func(err error) {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%v: rendered %v\n", time.Now().Format(time.TimeOnly), strings.Join(flag.Args(), " "))
+			}
+		}))
+	default:
+		check(render())
+	}
+	check(writeProfile(p))
+}
+
+// writeProfile reports the profile on stderr for -profile, and writes it to a file for -pprof.
+func writeProfile(p *processor.Processor) error {
+	if *profile {
+		if err := p.Profile().Report(os.Stderr); err != nil {
+			return err
+		}
+	}
+	if *profileOutput == "" {
+		return nil
+	}
+	f, err := os.Create(*profileOutput)
+	if err != nil {
+		return err
+	}
+	if err := p.Profile().WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
+// serve runs "gtpl [FLAGS] serve -listen ADDRESS -templates DIR", which renders the templates of a directory over HTTP,
+// see package server. The -prelude files precede each template.
+func serve(p *processor.Processor, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	templates := fs.String("templates", "", "directory of templates (files ending in .tpl) to serve")
+	flagnames.PatchFlagSet(fs, &args)
+	fs.Parse(args)
+	if *templates == "" || fs.NArg() > 0 {
+		return errors.New("usage: gtpl [FLAGS] serve [-listen ADDRESS] -templates DIR")
+	}
+	rd, err := p.Renderer(preludes, *templates)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving %v templates on http://%v\n", len(rd.Names()), *listen)
+	return http.ListenAndServe(*listen, server.New(rd))
 }
 
 func check(err error) {
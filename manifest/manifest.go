@@ -0,0 +1,175 @@
+// Package manifest runs batches of gtpl jobs, which are listed in a manifest file.
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KarelKubat/gtpl/processor"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the content of a manifest file.
+type Manifest struct {
+	Workers int      `yaml:"workers"` // Number of jobs that run concurrently, when 0 the number of CPUs
+	Prelude []string `yaml:"prelude"` // Template files that precede the inputs of each job, parsed once
+	Jobs    []*Job   `yaml:"jobs"`    // Jobs to run
+}
+
+// Job is one run of gtpl. Paths are relative to the directory of the manifest.
+type Job struct {
+	Name             string   `yaml:"name"`               // Name for the summary, defaults to the output
+	Inputs           []string `yaml:"inputs"`             // Template files
+	Data             []string `yaml:"data"`               // Data files, "FILE" or "NAME=FILE"
+	Values           []string `yaml:"values"`             // Overrides, "key.path=value"
+	IncludePath      []string `yaml:"include-path"`       // Directories for included files
+	Output           string   `yaml:"output"`             // Output file, required
+	OutputDir        string   `yaml:"destdir"`            // Directory for files written using "file"
+	LeftDelimiter    string   `yaml:"left-delimiter"`     // When "", defaults to "{{"
+	RightDelimiter   string   `yaml:"right-delimiter"`    // When "", defaults to "}}"
+	RemoveEmptyLines bool     `yaml:"remove-empty-lines"` // When true, remove empty lines from the output
+}
+
+// Result is the outcome of a job.
+type Result struct {
+	Job      *Job
+	Duration time.Duration
+	Err      error
+}
+
+// String returns a one-line summary of a result.
+func (r *Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("FAIL %v: %v", r.Job.Name, r.Err)
+	}
+	return fmt.Sprintf("ok   %v (%v)", r.Job.Name, r.Duration.Round(time.Millisecond))
+}
+
+// Load reads a manifest file. Relative paths of jobs are made relative to the directory of the manifest.
+func Load(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for k := range m.Prelude {
+		m.Prelude[k] = relativeTo(dir, m.Prelude[k])
+	}
+	for i, j := range m.Jobs {
+		if j.Output == "" {
+			return nil, fmt.Errorf("%v: job %v: output is required", path, i+1)
+		}
+		if len(j.Inputs) == 0 {
+			return nil, fmt.Errorf("%v: job %v: inputs are required", path, i+1)
+		}
+		if j.Name == "" {
+			j.Name = j.Output
+		}
+		for _, list := range [][]string{j.Inputs, j.IncludePath} {
+			for k := range list {
+				list[k] = relativeTo(dir, list[k])
+			}
+		}
+		for k, spec := range j.Data {
+			if name, file, ok := strings.Cut(spec, "="); ok {
+				j.Data[k] = name + "=" + relativeTo(dir, file)
+			} else {
+				j.Data[k] = relativeTo(dir, spec)
+			}
+		}
+		j.Output = relativeTo(dir, j.Output)
+		if j.OutputDir != "" {
+			j.OutputDir = relativeTo(dir, j.OutputDir)
+		}
+	}
+	return m, nil
+}
+
+// Run runs the jobs of a manifest concurrently and returns their results, in the order of the jobs. The defaults
+// provide settings that a manifest doesn't have, such as the logger. The prelude is parsed once using the defaults,
+// and each job parses only its own inputs into a copy of it. When profiling, the jobs add to defaults.ProfileTo.
+func (m *Manifest) Run(defaults processor.Opts) []*Result {
+	results := make([]*Result, len(m.Jobs))
+	p := processor.New(&defaults)
+	if defaults.Profile && defaults.ProfileTo == nil {
+		defaults.ProfileTo = p.Profile()
+	}
+	pre, err := p.ParsePrelude(m.Prelude)
+	if err != nil {
+		for i, j := range m.Jobs {
+			results[i] = &Result{
+				Job: j,
+				Err: err,
+			}
+		}
+		return results
+	}
+
+	workers := m.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	todo := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				results[i] = m.Jobs[i].run(defaults, pre)
+			}
+		}()
+	}
+	for i := range m.Jobs {
+		todo <- i
+	}
+	close(todo)
+	wg.Wait()
+	return results
+}
+
+// run runs one job after the prelude, using its own processor. Settings of the job are added to the defaults (data
+// files, values, the include path), or replace them (delimiters, the output directory).
+func (j *Job) run(defaults processor.Opts, pre *processor.Prelude) *Result {
+	start := time.Now()
+	o := defaults
+	if j.LeftDelimiter != "" {
+		o.LeftDelimiter = j.LeftDelimiter
+	}
+	if j.RightDelimiter != "" {
+		o.RightDelimter = j.RightDelimiter
+	}
+	if j.OutputDir != "" {
+		o.OutputDir = j.OutputDir
+	}
+	o.RemoveEmptyLines = o.RemoveEmptyLines || j.RemoveEmptyLines
+	o.DataFiles = append(append([]string(nil), o.DataFiles...), j.Data...)
+	o.Values = append(append([]string(nil), o.Values...), j.Values...)
+	o.IncludePath = append(append([]string(nil), o.IncludePath...), j.IncludePath...)
+	err := os.MkdirAll(filepath.Dir(j.Output), 0755)
+	if err == nil {
+		err = processor.New(&o).ProcessToFileWithPrelude(pre, j.Inputs, j.Output)
+	}
+	return &Result{
+		Job:      j,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+}
+
+// relativeTo makes a relative path relative to a directory, absolute paths and stdin ("-") are left alone.
+func relativeTo(dir, path string) string {
+	if path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
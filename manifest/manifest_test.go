@@ -0,0 +1,156 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/KarelKubat/gtpl/processor"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	f := filepath.Join(dir, name)
+	if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v, need nil error", f, err)
+	}
+	return f
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		content   string
+		wantError bool
+	}{
+		{
+			content:   "jobs:\n  - inputs: [a.tpl]\n    output: a.out\n    data: [d.yaml, n=e.yaml]\n",
+			wantError: false,
+		},
+		{
+			content:   "jobs:\n  - inputs: [a.tpl]\n",
+			wantError: true,
+		},
+		{
+			content:   "jobs:\n  - output: a.out\n",
+			wantError: true,
+		},
+		{
+			content:   "jobs: [",
+			wantError: true,
+		},
+	} {
+		path := writeFile(t, dir, "build.yaml", test.content)
+		m, err := Load(path)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("Load(%q) = _,%v, want error: %v", test.content, err, test.wantError)
+		}
+		if err != nil {
+			continue
+		}
+		j := m.Jobs[0]
+		if j.Name != "a.out" {
+			t.Errorf("Load(%q): name = %q, want a.out", test.content, j.Name)
+		}
+		if want := filepath.Join(dir, "a.tpl"); j.Inputs[0] != want {
+			t.Errorf("Load(%q): input = %q, want %q", test.content, j.Inputs[0], want)
+		}
+		if want := "n=" + filepath.Join(dir, "e.yaml"); j.Data[1] != want {
+			t.Errorf("Load(%q): data = %q, want %q", test.content, j.Data[1], want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "common.tpl", `<<- $greeting := "hello" ->>`)
+	writeFile(t, dir, "one.tpl", `<< $greeting >> << .Data.who >>`)
+	writeFile(t, dir, "two.tpl", "\n<< $greeting >> << .Data.who >>\n\n")
+	writeFile(t, dir, "broken.tpl", `<< die "broken" >>`)
+	path := writeFile(t, dir, "build.yaml", `
+workers: 2
+jobs:
+  - name: one
+    inputs: [common.tpl, one.tpl]
+    output: out/one
+    values: [who=one]
+  - name: two
+    inputs: [common.tpl, two.tpl]
+    output: out/two
+    values: [who=two]
+    remove-empty-lines: true
+  - name: broken
+    inputs: [broken.tpl]
+    output: out/broken
+`)
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(%q) = _,%v, need nil error", path, err)
+	}
+	results := m.Run(processor.Opts{
+		AllowAliases:  true,
+		LeftDelimiter: "<<",
+		RightDelimter: ">>",
+	})
+	if len(results) != 3 {
+		t.Fatalf("Run(...) returned %v results, want 3", len(results))
+	}
+	for i, want := range []string{"hello one", "hello two\n"} {
+		if results[i].Err != nil {
+			t.Errorf("Run(...): job %v failed: %v", results[i].Job.Name, results[i].Err)
+		}
+		f := results[i].Job.Output
+		if b, _ := os.ReadFile(f); string(b) != want {
+			t.Errorf("Run(...): %v holds %q, want %q", f, string(b), want)
+		}
+	}
+	if r := results[2]; r.Err == nil || !strings.HasPrefix(r.String(), "FAIL broken: ") {
+		t.Errorf("Run(...): result for broken job is %q, want a failure", r.String())
+	}
+}
+
+func TestRunPrelude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "common.tpl", `{{- $greeting := "hello" -}}`)
+	writeFile(t, dir, "one.tpl", `{{ $greeting }} {{ add 1 1 }}`)
+	writeFile(t, dir, "two.tpl", `[[ $greeting ]] [[ add 1 2 ]]`)
+	path := writeFile(t, dir, "build.yaml", `
+prelude: [common.tpl]
+jobs:
+  - inputs: [one.tpl]
+    output: out/one
+  - inputs: [two.tpl]
+    output: out/two
+    left-delimiter: "[["
+    right-delimiter: "]]"
+`)
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(%q) = _,%v, need nil error", path, err)
+	}
+	// The prelude uses the delimiters of the defaults, the inputs of jobs their own ones. All jobs add to one profile.
+	profile := processor.New(&processor.Opts{Profile: true}).Profile()
+	results := m.Run(processor.Opts{
+		AllowAliases: true,
+		Profile:      true,
+		ProfileTo:    profile,
+	})
+	for i, want := range []string{"hello 2", "hello 3"} {
+		if results[i].Err != nil {
+			t.Errorf("Run(...): job %v failed: %v", results[i].Job.Name, results[i].Err)
+		}
+		f := results[i].Job.Output
+		if b, _ := os.ReadFile(f); string(b) != want {
+			t.Errorf("Run(...): %v holds %q, want %q", f, string(b), want)
+		}
+	}
+	var buf strings.Builder
+	if err := profile.Report(&buf); err != nil {
+		t.Fatalf("Report(...) = %v, need nil error", err)
+	}
+	if !regexp.MustCompile(`(?m)^add\s+2\s`).MatchString(buf.String()) {
+		t.Errorf("Run(...): profile doesn't hold the 2 calls of add of both jobs:\n%v", buf.String())
+	}
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	blockComment = "#" // Default start of comments in marker lines of managed blocks
+)
+
+// blockMarkers returns the marker lines that surround a managed block, such as "# BEGIN gtpl:ssh" and
+// "# END gtpl:ssh".
+func (p *Processor) blockMarkers() (string, string) {
+	comment := p.o.ManagedComment
+	if comment == "" {
+		comment = blockComment
+	}
+	return fmt.Sprintf("%v BEGIN %v:%v", comment, mainTemplate, p.o.ManagedBlock),
+		fmt.Sprintf("%v END %v:%v", comment, mainTemplate, p.o.ManagedBlock)
+}
+
+// mainContent returns what the output file should hold, given the output of processing. That is the output itself,
+// unless a managed block is requested: then it's the current content of the file, where only the managed block is
+// replaced.
+func (p *Processor) mainContent(path string, out []byte) ([]byte, error) {
+	if p.o.ManagedBlock == "" {
+		return out, nil
+	}
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	begin, end := p.blockMarkers()
+	merged, err := mergeBlock(string(old), begin, end, string(out))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return []byte(merged), nil
+}
+
+// mergeBlock replaces the lines between the begin and end markers by the content. When the markers are absent, the
+// block is appended. Lines outside the markers are left untouched. Marker lines may be indented.
+func mergeBlock(old, begin, end, content string) (string, error) {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	block := begin + "\n" + content + end + "\n"
+
+	lines := strings.SplitAfter(old, "\n")
+	first, last := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case begin:
+			if first >= 0 {
+				return "", fmt.Errorf("%q occurs more than once", begin)
+			}
+			first = i
+		case end:
+			if first < 0 {
+				return "", fmt.Errorf("%q without preceding %q", end, begin)
+			}
+			if last >= 0 {
+				return "", fmt.Errorf("%q occurs more than once", end)
+			}
+			last = i
+		}
+	}
+	switch {
+	case first < 0:
+		if old != "" && !strings.HasSuffix(old, "\n") {
+			old += "\n"
+		}
+		return old + block, nil
+	case last < 0:
+		return "", fmt.Errorf("%q without %q", begin, end)
+	}
+
+	// Keep the marker lines as they are, in case they are indented.
+	return strings.Join(lines[:first+1], "") + content + strings.Join(lines[last:], ""), nil
+}
@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeBlock(t *testing.T) {
+	const (
+		begin = "# BEGIN gtpl:ssh"
+		end   = "# END gtpl:ssh"
+	)
+	for _, test := range []struct {
+		old       string
+		content   string
+		want      string
+		wantError bool
+	}{
+		{
+			// Absent files get just the block.
+			old:     "",
+			content: "new",
+			want:    "# BEGIN gtpl:ssh\nnew\n# END gtpl:ssh\n",
+		},
+		{
+			// Absent blocks are appended.
+			old:     "manual",
+			content: "new\n",
+			want:    "manual\n# BEGIN gtpl:ssh\nnew\n# END gtpl:ssh\n",
+		},
+		{
+			// Existing blocks are replaced, everything else stays.
+			old:     "top\n# BEGIN gtpl:ssh\nold\nolder\n# END gtpl:ssh\nbottom\n",
+			content: "new\n",
+			want:    "top\n# BEGIN gtpl:ssh\nnew\n# END gtpl:ssh\nbottom\n",
+		},
+		{
+			// Indented markers are kept as they are, empty content empties the block.
+			old:     "top\n  # BEGIN gtpl:ssh\nold\n  # END gtpl:ssh",
+			content: "",
+			want:    "top\n  # BEGIN gtpl:ssh\n  # END gtpl:ssh",
+		},
+		{
+			// Other blocks are left alone.
+			old:     "# BEGIN gtpl:hosts\nold\n# END gtpl:hosts\n",
+			content: "new",
+			want:    "# BEGIN gtpl:hosts\nold\n# END gtpl:hosts\n# BEGIN gtpl:ssh\nnew\n# END gtpl:ssh\n",
+		},
+		{
+			old:       "# BEGIN gtpl:ssh\nold\n",
+			wantError: true,
+		},
+		{
+			old:       "# END gtpl:ssh\n# BEGIN gtpl:ssh\n",
+			wantError: true,
+		},
+		{
+			old:       "# BEGIN gtpl:ssh\n# BEGIN gtpl:ssh\n# END gtpl:ssh\n",
+			wantError: true,
+		},
+		{
+			old:       "# BEGIN gtpl:ssh\n# END gtpl:ssh\n# END gtpl:ssh\n",
+			wantError: true,
+		},
+	} {
+		got, err := mergeBlock(test.old, begin, end, test.content)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("mergeBlock(%q, %q) = _,%v, want error: %v", test.old, test.content, err, test.wantError)
+		}
+		if got != test.want {
+			t.Errorf("mergeBlock(%q, %q) = %q, want %q", test.old, test.content, got, test.want)
+		}
+	}
+}
+
+func TestManagedBlock(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config", "// manual\n// BEGIN gtpl:gen\nold\n// END gtpl:gen\n")
+	tpl := writeFile(t, dir, "gen.tpl", "{{ $x := 42 }}generated {{ $x }}\n")
+	p := New(&Opts{ManagedBlock: "gen", ManagedComment: "//"})
+
+	want := "// manual\n// BEGIN gtpl:gen\ngenerated 42\n// END gtpl:gen\n"
+	d, err := p.Compare([]string{tpl}, path)
+	if err != nil {
+		t.Fatalf("Compare(...) = _,%v, need nil error", err)
+	}
+	if !strings.Contains(d, "-old\n+generated 42\n") {
+		t.Errorf("Compare(...) = %q, want the block to change", d)
+	}
+	if err := p.ProcessToFile([]string{tpl}, path); err != nil {
+		t.Fatalf("ProcessToFile(...) = %v, need nil error", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("after ProcessToFile(...): %v holds %q, want %q", path, string(b), want)
+	}
+	if d, err := p.Compare([]string{tpl}, path); err != nil || d != "" {
+		t.Errorf("Compare(...) after ProcessToFile(...) = %q,%v, want \"\",nil", d, err)
+	}
+
+	// Unterminated blocks are not touched.
+	broken := writeFile(t, dir, "broken", "// BEGIN gtpl:gen\nold\n")
+	if err := p.ProcessToFile([]string{tpl}, broken); err == nil {
+		t.Errorf("ProcessToFile(..., %q) = nil, want error", filepath.Base(broken))
+	}
+	if b, _ := os.ReadFile(broken); string(b) != "// BEGIN gtpl:gen\nold\n" {
+		t.Errorf("after failing ProcessToFile(...): %v holds %q", broken, string(b))
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPositions(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", `{{ define "port" }}{{ check (haskey . "port") "no port for" .name }}{{ end }}`)
+	hosts := writeFile(t, dir, "hosts.tpl", `{{ import "lib.tpl" }}
+{{- range $h := list (map "name" "a") (map "name" "b" "port" 22) (map "name" "c") }}
+  {{- template "port" $h }}
+{{- end }}
+  {{ .Gtpl.Check false "long name" }}
+{{ assert false "assertion" }}
+{{ die "dead" }}
+`)
+	for _, test := range []struct {
+		keepGoing bool
+		want      []string
+	}{
+		{
+			keepGoing: false,
+			want: []string{
+				lib + ":1:22: check: no port for a",
+				lib + ":1:22: check: no port for c",
+				hosts + ":5:5: check: long name",
+				hosts + ":6:3: executing",
+			},
+		},
+		{
+			keepGoing: true,
+			want: []string{
+				lib + ":1:22: check: no port for a",
+				lib + ":1:22: check: no port for c",
+				hosts + ":5:5: check: long name",
+				hosts + ":6:3: assert: assertion",
+				hosts + ":7:3: dead",
+			},
+		},
+	} {
+		out := filepath.Join(dir, "out")
+		p := New(&Opts{AllowAliases: true, KeepGoing: test.keepGoing})
+		err := p.ProcessToFile([]string{hosts}, out)
+		if err == nil {
+			t.Errorf("with KeepGoing %v: ProcessToFile(...) = nil, want error", test.keepGoing)
+			continue
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(test.want) {
+			t.Errorf("with KeepGoing %v: ProcessToFile(...) = %q, want %v lines", test.keepGoing, err, len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("with KeepGoing %v: ProcessToFile(...) line %v = %q, want prefix %q",
+					test.keepGoing, i+1, lines[i], want)
+			}
+		}
+		if _, err := os.Stat(out); err == nil {
+			t.Errorf("with KeepGoing %v: ProcessToFile(...) wrote output, want nothing written", test.keepGoing)
+		}
+	}
+}
+
+func TestWarn(t *testing.T) {
+	l := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, Logger: &bufLogger{l}})
+	var buf bytes.Buffer
+	if err := p.ProcessStreams(strings.NewReader("a\n  {{ warn \"careful\" }}b"), &buf); err != nil {
+		t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+	}
+	if buf.String() != "a\n  b" {
+		t.Errorf("ProcessStreams(...) wrote %q, want %q", buf.String(), "a\n  b")
+	}
+	if want := "gtpl: stdin:2:5: warning: careful"; l.String() != want {
+		t.Errorf("ProcessStreams(...) logged %q, want %q", l.String(), want)
+	}
+}
+
+type bufLogger struct {
+	buf *bytes.Buffer
+}
+
+func (b *bufLogger) Print(v ...interface{}) {
+	for _, x := range v {
+		b.buf.WriteString(x.(string))
+	}
+}
+
+func TestFailuresNotReachable(t *testing.T) {
+	// Templates can't discard their own failures.
+	p := New(&Opts{AllowAliases: true})
+	var buf bytes.Buffer
+	err := p.ProcessStreams(strings.NewReader(`{{ check false "a" }}{{ .Gtpl.Failures }}ok`), &buf)
+	if err == nil {
+		t.Errorf("ProcessStreams(...) = nil, want error")
+	}
+}
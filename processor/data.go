@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadData reads structured data files (JSON or YAML) and returns them as one map, which is exposed to templates as
+// `.Data`. A file is stated as "FILE" or as "NAME=FILE". In the first form, the document must be a map, and its
+// keys are merged into the returned map. In the second form, the document is stored under the key NAME.
+func loadData(files []string) (map[interface{}]interface{}, error) {
+	data := map[interface{}]interface{}{}
+	for _, spec := range files {
+		name, file := "", spec
+		if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 {
+			name, file = parts[0], parts[1]
+		}
+		doc, err := loadDataFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			data[name] = doc
+			continue
+		}
+		m, ok := doc.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: data is not a map, use NAME=%v to store it under a name", file, file)
+		}
+		for k, v := range m {
+			data[k] = v
+		}
+	}
+	return data, nil
+}
+
+// dataFileName returns the file of a data file specification "FILE" or "NAME=FILE".
+func dataFileName(spec string) string {
+	if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return spec
+}
+
+// loadDataFile decodes one JSON or YAML file. Files ending in .json are decoded as JSON, everything else as YAML.
+func loadDataFile(file string) (interface{}, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if strings.ToLower(filepath.Ext(file)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	} else {
+		err = yaml.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	return normalize(doc), nil
+}
+
+// normalize converts decoded data into the types that the builtins understand: maps become
+// map[interface{}]interface{} (as if created by `map`), lists become []interface{} (as if created by `list`), and JSON
+// numbers become ints or floats.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, e := range val {
+			out[k] = normalize(e)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, e := range val {
+			out[k] = normalize(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, e := range val {
+			out[i] = normalize(e)
+		}
+		return out
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	default:
+		return v
+	}
+}
+
+// setValues applies "key.path=value" overrides to the data. Path elements select keys in maps; in lists, numeric
+// elements select indexes. Maps are created when absent. Values that look like ints, floats or booleans are stored as
+// such, anything else is stored as a string.
+func setValues(data map[interface{}]interface{}, values []string) error {
+	for _, spec := range values {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("value %q: not in the format key.path=value", spec)
+		}
+		if err := setPath(data, strings.Split(parts[0], "."), inferValue(parts[1])); err != nil {
+			return fmt.Errorf("value %q: %v", spec, err)
+		}
+	}
+	return nil
+}
+
+// setPath descends into a map or list, following the path, and sets the value at its end.
+func setPath(container interface{}, path []string, val interface{}) error {
+	key := path[0]
+	switch c := container.(type) {
+	case map[interface{}]interface{}:
+		if len(path) == 1 {
+			c[key] = val
+			return nil
+		}
+		next, ok := c[key]
+		if !ok {
+			next = map[interface{}]interface{}{}
+			c[key] = next
+		}
+		return setPath(next, path[1:], val)
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(c) {
+			return fmt.Errorf("%q is not an index in a list of %v elements", key, len(c))
+		}
+		if len(path) == 1 {
+			c[i] = val
+			return nil
+		}
+		return setPath(c[i], path[1:], val)
+	default:
+		return fmt.Errorf("cannot set %q in %v, which is neither a map nor a list", key, container)
+	}
+}
+
+// decimalFloat matches floats as they are usually written: no leading zeros, no hex, no "inf" or "nan".
+var decimalFloat = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// inferValue converts a string into an int, float or bool when it looks like one. Numbers that would lose their
+// spelling, such as "01234" or "0x10", stay strings.
+func inferValue(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil && strconv.Itoa(i) == s {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && decimalFloat.MatchString(s) {
+		return f
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	f := filepath.Join(dir, name)
+	if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v, need nil error", f, err)
+	}
+	return f
+}
+
+func TestLoadData(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := writeFile(t, dir, "hosts.yaml", "hosts:\n  - hostname: ws\n    port: 2222\n")
+	jsonFile := writeFile(t, dir, "env.json", `{"env": "prod", "ratio": 0.5, "count": 3}`)
+	listFile := writeFile(t, dir, "list.json", `[1, 2, 3]`)
+
+	data, err := loadData([]string{yamlFile, jsonFile, "nums=" + listFile})
+	if err != nil {
+		t.Fatalf("loadData(...) = _,%v, need nil error", err)
+	}
+	hosts, ok := data["hosts"].([]interface{})
+	if !ok || len(hosts) != 1 {
+		t.Fatalf("loadData(...): hosts = %v, want a list of 1 element", data["hosts"])
+	}
+	host, ok := hosts[0].(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("loadData(...): host = %T, want map[interface{}]interface{}", hosts[0])
+	}
+	if host["port"] != 2222 {
+		t.Errorf("loadData(...): port = %v (%T), want int 2222", host["port"], host["port"])
+	}
+	if data["env"] != "prod" || data["ratio"] != 0.5 || data["count"] != 3 {
+		t.Errorf("loadData(...): env/ratio/count = %v/%v/%v, want prod/0.5/3", data["env"], data["ratio"], data["count"])
+	}
+	if nums, ok := data["nums"].([]interface{}); !ok || len(nums) != 3 {
+		t.Errorf("loadData(...): nums = %v, want a list of 3 elements", data["nums"])
+	}
+
+	// A document that isn't a map must be named.
+	if _, err := loadData([]string{listFile}); err == nil {
+		t.Errorf("loadData(%q) = _,nil, want error", listFile)
+	}
+	// Non-existing files fail.
+	if _, err := loadData([]string{filepath.Join(dir, "nonexisting.yaml")}); err == nil {
+		t.Error("loadData(nonexisting.yaml) = _,nil, want error")
+	}
+}
+
+func TestProcessStreamsWithData(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := writeFile(t, dir, "hosts.yaml", "hosts:\n  - hostname: ws\n    port: 2222\n")
+	tpl := `{{ range $h := .Data.hosts }}{{ getval $h "hostname" }}:{{ getval $h "port" }}{{ end }}`
+	wr := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, DataFiles: []string{yamlFile}})
+	if err := p.ProcessStreams(strings.NewReader(tpl), wr); err != nil {
+		t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+	}
+	if want := "ws:2222"; wr.String() != want {
+		t.Errorf("ProcessStreams(...): output is %q, want %q", wr.String(), want)
+	}
+}
+
+func TestSetValues(t *testing.T) {
+	data := map[interface{}]interface{}{
+		"hosts": []interface{}{
+			map[interface{}]interface{}{"hostname": "ws"},
+		},
+	}
+	if err := setValues(data, []string{"hosts.0.port=2222", "env=prod", "a.b.ratio=0.5", "debug=true", "name=inf"}); err != nil {
+		t.Fatalf("setValues(...) = %v, need nil error", err)
+	}
+	host := data["hosts"].([]interface{})[0].(map[interface{}]interface{})
+	if host["port"] != 2222 {
+		t.Errorf("setValues(...): port = %v (%T), want int 2222", host["port"], host["port"])
+	}
+	if data["env"] != "prod" {
+		t.Errorf("setValues(...): env = %v, want prod", data["env"])
+	}
+	if ratio := data["a"].(map[interface{}]interface{})["b"].(map[interface{}]interface{})["ratio"]; ratio != 0.5 {
+		t.Errorf("setValues(...): a.b.ratio = %v (%T), want float 0.5", ratio, ratio)
+	}
+	if data["debug"] != true {
+		t.Errorf("setValues(...): debug = %v (%T), want bool true", data["debug"], data["debug"])
+	}
+	if data["name"] != "inf" {
+		t.Errorf("setValues(...): name = %v (%T), want string inf", data["name"], data["name"])
+	}
+
+	for _, bad := range []string{"noequalsign", "=value", "hosts.1.port=22", "env.sub=x"} {
+		if err := setValues(data, []string{bad}); err == nil {
+			t.Errorf("setValues(%q) = nil, want error", bad)
+		}
+	}
+}
+
+func TestInferValue(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want interface{}
+	}{
+		{in: "22", want: 22},
+		{in: "-3", want: -3},
+		{in: "0", want: 0},
+		{in: "01234", want: "01234"},
+		{in: "+1", want: "+1"},
+		{in: "0x10", want: "0x10"},
+		{in: "0.5", want: 0.5},
+		{in: "1.0", want: 1.0},
+		{in: "1e3", want: 1000.0},
+		{in: "00.5", want: "00.5"},
+		{in: "inf", want: "inf"},
+		{in: "true", want: true},
+		{in: "prod", want: "prod"},
+	} {
+		if got := inferValue(test.in); got != test.want {
+			t.Errorf("inferValue(%q) = %v (%T), want %v (%T)", test.in, got, got, test.want, test.want)
+		}
+	}
+}
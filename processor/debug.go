@@ -0,0 +1,191 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	debugPrompt = "debug> " // Prompt of the inspector
+	debugHelp   = `:continue (or :c) resumes, :abort stops, :dot shows the dot, :vars the variables, :templates the
+defined templates, :builtins the builtins; pipelines (e.g. "len .hosts") and snippets are executed against the dot`
+)
+
+// internalTemplate matches the names of templates that hold input files or REPL lines, rather than definitions.
+var internalTemplate = regexp.MustCompile(`^(` + mainTemplate + `|` + preludeTemplate + `|` + replName + `|` +
+	replVarsTemplate + `)(-\d+)?$`)
+
+// walkScoped calls fn for every command in the parse tree below n, with the variables that are in scope there (such
+// as "$x", excluding "$"). It returns the variables that are in scope after n.
+func walkScoped(n parse.Node, scope []string, fn func(*parse.CommandNode, []string)) []string {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return scope
+		}
+		inner := scope
+		for _, sub := range n.Nodes {
+			inner = walkScoped(sub, inner, fn)
+		}
+	case *parse.ActionNode:
+		walkScoped(n.Pipe, scope, fn)
+		return declare(scope, n.Pipe)
+	case *parse.IfNode:
+		walkScopedBranch(&n.BranchNode, scope, fn)
+	case *parse.RangeNode:
+		walkScopedBranch(&n.BranchNode, scope, fn)
+	case *parse.WithNode:
+		walkScopedBranch(&n.BranchNode, scope, fn)
+	case *parse.TemplateNode:
+		walkScoped(n.Pipe, scope, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return scope
+		}
+		for _, cmd := range n.Cmds {
+			walkScoped(cmd, scope, fn)
+		}
+	case *parse.CommandNode:
+		fn(n, scope)
+		for _, arg := range n.Args {
+			walkScoped(arg, scope, fn)
+		}
+	case *parse.ChainNode:
+		walkScoped(n.Node, scope, fn)
+	}
+	return scope
+}
+
+// walkScopedBranch walks the parts of an if, range or with. Variables that the pipeline declares are in scope in the
+// branches.
+func walkScopedBranch(b *parse.BranchNode, scope []string, fn func(*parse.CommandNode, []string)) {
+	walkScoped(b.Pipe, scope, fn)
+	inner := declare(scope, b.Pipe)
+	walkScoped(b.List, inner, fn)
+	walkScoped(b.ElseList, inner, fn)
+}
+
+// declare returns the scope with the variables that a pipeline declares. The scope that is passed in isn't changed.
+func declare(scope []string, pipe *parse.PipeNode) []string {
+	if pipe == nil || pipe.IsAssign || len(pipe.Decl) == 0 {
+		return scope
+	}
+	out := append([]string(nil), scope...)
+	for _, v := range pipe.Decl {
+		found := false
+		for _, name := range out {
+			found = found || name == v.Ident[0]
+		}
+		if !found {
+			out = append(out, v.Ident[0])
+		}
+	}
+	return out
+}
+
+// addBreakpoints rewrites calls of "breakpoint" in all templates of a set to calls of "breakpointat", which get the
+// position, the dot and the variables in scope as name/value pairs. The parts map positions to input files.
+func (p *Processor) addBreakpoints(tpl *template.Template, ps parts) {
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		walkScoped(t.Tree.Root, nil, func(cmd *parse.CommandNode, scope []string) {
+			if p.builtinAt(cmd) != "Breakpoint" {
+				return
+			}
+			loc, _ := t.ErrorContext(cmd)
+			loc = ps.fix(errors.New(loc)).Error()
+			p.rewritten.add(cmd, 0)
+			p.rewritten.add(cmd.Args[0], 0)
+			p.call(cmd, "BreakpointAt")
+			pos := cmd.Position()
+			args := []parse.Node{
+				cmd.Args[0],
+				&parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: fmt.Sprintf("%q", loc), Text: loc},
+				&parse.DotNode{NodeType: parse.NodeDot, Pos: pos},
+			}
+			for _, name := range append([]string{"$"}, scope...) {
+				args = append(args,
+					&parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: fmt.Sprintf("%q", name), Text: name},
+					&parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: []string{name}})
+			}
+			cmd.Args = args
+		})
+	}
+}
+
+// inspector satisfies syringe.Debugger. At a breakpoint, it reads commands from the debug input, see debugHelp.
+type inspector struct {
+	p     *Processor
+	tpl   *template.Template      // Template that is executing
+	abort context.CancelCauseFunc // Stops execution
+}
+
+// Break shows where execution is and reads commands until execution continues or is aborted. The commands run in a
+// clone of the template set, so that definitions and variables that they set don't affect execution.
+func (in *inspector) Break(pos string, dot interface{}, vars map[string]interface{}) error {
+	set, err := in.tpl.Clone()
+	if err != nil {
+		return err
+	}
+	s := in.p.newSession(set, vars, in.p.debugOutput())
+	s.commands = ":continue, :abort, :dot, :templates, :builtins, :vars and :load FILE"
+	s.execute = func(t *template.Template, w io.Writer) error {
+		return t.Execute(w, dot)
+	}
+	fmt.Fprintf(s.w, "breakpoint at %v, the dot is %v\n%v\n", pos, s.show(dot), debugHelp)
+	return s.read(in.p.debugInput(), debugPrompt, func(line string) (bool, error) {
+		cmd, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch cmd {
+		case ":continue", ":c":
+			return true, nil
+		case ":abort":
+			in.abort(fmt.Errorf("%v: aborted at breakpoint", pos))
+			return true, nil
+		case ":dot":
+			fmt.Fprintln(s.w, s.show(dot))
+			return false, nil
+		case ":templates":
+			var names []string
+			for _, t := range set.Templates() {
+				if t.Tree != nil && !internalTemplate.MatchString(t.Name()) {
+					names = append(names, t.Name())
+				}
+			}
+			sort.Strings(names)
+			fmt.Fprintln(s.w, strings.Join(names, "\n"))
+			return false, nil
+		}
+		return false, s.line(line)
+	})
+}
+
+// debugInput returns the reader of commands for the inspector, which persists from breakpoint to breakpoint.
+func (p *Processor) debugInput() *bufio.Scanner {
+	if p.debugIn == nil {
+		var r io.Reader = os.Stdin
+		if p.o.DebugInput != nil {
+			r = p.o.DebugInput
+		}
+		p.debugIn = bufio.NewScanner(r)
+	}
+	return p.debugIn
+}
+
+// debugOutput returns the writer of the inspector.
+func (p *Processor) debugOutput() io.Writer {
+	if p.o.DebugOutput != nil {
+		return p.o.DebugOutput
+	}
+	return os.Stderr
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template/parse"
+)
+
+func TestWalkScoped(t *testing.T) {
+	trees, err := parse.Parse("t", `{{ $a := 1 }}{{ f }}{{ range $i, $e := g }}{{ $b := 2 }}{{ f }}{{ end }}{{ f }}`+
+		`{{ with $c := 3 }}{{ f }}{{ else }}{{ f }}{{ end }}{{ $a = 4 }}{{ f }}`, "{{", "}}",
+		map[string]interface{}{"f": fmt.Sprint, "g": fmt.Sprint})
+	if err != nil {
+		t.Fatalf("parse.Parse(...) = _,%v, need nil error", err)
+	}
+	var got [][]string
+	walkScoped(trees["t"].Root, nil, func(cmd *parse.CommandNode, scope []string) {
+		if cmd.Args[0].String() == "f" {
+			got = append(got, scope)
+		}
+	})
+	want := [][]string{
+		{"$a"},
+		{"$a", "$i", "$e", "$b"},
+		{"$a"},
+		{"$a", "$c"},
+		{"$a", "$c"},
+		{"$a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walkScoped(...) gave scopes %v, want %v", got, want)
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	tpl := `{{ define "party" }}{{ range $k, $v := . }}{{ $k }}{{ breakpoint }} {{ end }}{{ end }}` +
+		`{{ $party := map "alice" 1 "bob" 2 }}{{ template "party" $party }}done`
+	for _, test := range []struct {
+		name      string
+		debug     bool
+		input     string
+		want      string // Output
+		wantShown []string
+		wantError string
+	}{
+		{
+			name: "without debugging, breakpoints do nothing",
+			want: "alice bob done",
+		},
+		{
+			name:  "inspect and continue",
+			debug: true,
+			input: ":dot\n$k\nadd $v 10\n{{ range $k2, $v2 := $ }}\n{{ $k2 }}{{ end }}\n:templates\n:c\n:vars\n:nope\n:c\n",
+			want:  "alice bob done",
+			wantShown: []string{
+				"breakpoint at stdin:1:54, the dot is 1 (int)",
+				"debug> 1 (int)\n",
+				"debug> alice (string)\n",
+				"debug> 11 (int)\n",
+				"debug> ... \nalice\nbob\n",
+				"debug> party\n",
+				"breakpoint at stdin:1:54, the dot is 2 (int)",
+				"$ = map[alice:1 bob:2] (map)\n$k = bob (string)\n$v = 2 (int)\n",
+				"error: unknown command :nope",
+			},
+		},
+		{
+			name:      "abort",
+			debug:     true,
+			input:     ":abort\n",
+			wantError: "stdin:1:54: aborted at breakpoint",
+		},
+		{
+			name:  "end of input continues",
+			debug: true,
+			want:  "alice bob done",
+		},
+	} {
+		var shown bytes.Buffer
+		p := New(&Opts{
+			AllowAliases: true,
+			Debug:        test.debug,
+			DebugInput:   strings.NewReader(test.input),
+			DebugOutput:  &shown,
+		})
+		var buf bytes.Buffer
+		err := p.ProcessStreams(strings.NewReader(tpl), &buf)
+		switch {
+		case err != nil && err.Error() != test.wantError:
+			t.Errorf("%v: ProcessStreams(...) = %v, want error %q", test.name, err, test.wantError)
+		case err == nil && test.wantError != "":
+			t.Errorf("%v: ProcessStreams(...) = nil, want error %q", test.name, test.wantError)
+		case err == nil && buf.String() != test.want:
+			t.Errorf("%v: ProcessStreams(...) wrote %q, want %q", test.name, buf.String(), test.want)
+		}
+		for _, want := range test.wantShown {
+			if !strings.Contains(shown.String(), want) {
+				t.Errorf("%v: inspector showed:\n%v\nwhich doesn't hold %q", test.name, shown.String(), want)
+			}
+		}
+	}
+}
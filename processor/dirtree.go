@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	templateExt = ".tpl" // Extension of templates in directory trees
+)
+
+// treeEntry is something to create in the destination of ProcessTree.
+type treeEntry struct {
+	path    string      // Path in the destination
+	mode    fs.FileMode // Mode, taken from the source
+	isDir   bool        // True for directories
+	content []byte      // Content for files
+}
+
+// ProcessTree renders a directory tree into another. Files ending in .tpl are processed as templates, preceded by the
+// prelude files (which may hold common settings); the output is written without the .tpl extension. Other files are
+// copied verbatim. File and directory names may hold template actions too, such as "{{ .Data.name }}.conf"; these are
+// expanded without the prelude. Modes are taken from the source. When Opts.ManagedBlock is set, only that block is
+// replaced in the output of templates. Nothing is written unless all templates succeed.
+func (p *Processor) ProcessTree(prelude []string, srcDir, dstDir string) error {
+	return p.ProcessTreeContext(context.Background(), prelude, srcDir, dstDir)
+}
+
+// ProcessTreeContext is ProcessTree that stops when a context is done.
+func (p *Processor) ProcessTreeContext(ctx context.Context, prelude []string, srcDir, dstDir string) error {
+	pre, err := readFiles(prelude)
+	if err != nil {
+		return err
+	}
+
+	var entries []treeEntry
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := p.expandName(ctx, rel)
+		if err != nil {
+			return err
+		}
+		entry := treeEntry{
+			path:  filepath.Join(dstDir, name),
+			mode:  info.Mode().Perm(),
+			isDir: d.IsDir(),
+		}
+		switch {
+		case d.IsDir():
+		case strings.HasSuffix(rel, templateExt):
+			entry.path = strings.TrimSuffix(entry.path, templateExt)
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			src, err := pre.with(path, b)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			r, err := p.execute(ctx, src, &buf)
+			if err != nil {
+				return err
+			}
+			if entry.content, err = p.mainContent(entry.path, buf.Bytes()); err != nil {
+				return err
+			}
+			for _, f := range r.files {
+				entries = append(entries, treeEntry{
+					path:    f.path,
+					mode:    0644,
+					content: f.content,
+				})
+			}
+		default:
+			if entry.content, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+			return err
+		}
+		if e.isDir {
+			if err := os.MkdirAll(e.path, e.mode|0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := replaceFile(e.path, e.content); err != nil {
+			return err
+		}
+		if err := os.Chmod(e.path, e.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandName expands template actions in a relative path, the result must be a relative path as well. The name is
+// not an output of its own, so a managed block or an output file in the options doesn't apply.
+func (p *Processor) expandName(ctx context.Context, rel string) (string, error) {
+	left := p.leftDelim
+	if left == "" {
+		left = "{{"
+	}
+	if !strings.Contains(rel, left) {
+		return rel, nil
+	}
+	src := &source{}
+	if err := src.add(stdinName, []byte(rel)); err != nil {
+		return "", fmt.Errorf("%v: %v", rel, err)
+	}
+	var buf bytes.Buffer
+	if _, err := p.execute(ctx, src, &buf); err != nil {
+		return "", fmt.Errorf("%v: %v", rel, err)
+	}
+	// Names don't end in a newline, but removing empty lines from the output adds one.
+	name := strings.TrimSuffix(buf.String(), "\n")
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%v: expands to %q, which is not a relative name", rel, name)
+	}
+	return name, nil
+}
@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	appDir := filepath.Join(src, "{{ .Data.app }}")
+	if err := os.MkdirAll(filepath.Join(appDir, "bin"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll(...) = %v, need nil error", err)
+	}
+	prelude := writeFile(t, dir, "prelude.tpl", `{{ $greeting := "hello" }}`)
+	writeFile(t, appDir, "config.tpl", `{{ $greeting }} {{ .Data.app }}`)
+	script := writeFile(t, filepath.Join(appDir, "bin"), "run.tpl", `echo {{ .Data.app }}`)
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatalf("os.Chmod(%q) = %v, need nil error", script, err)
+	}
+	writeFile(t, src, "static.txt", `{{ not expanded }}`)
+
+	p := New(&Opts{AllowAliases: true, Values: []string{"app=demo"}})
+	if err := p.ProcessTree([]string{prelude}, src, dst); err != nil {
+		t.Fatalf("ProcessTree(...) = %v, need nil error", err)
+	}
+	for _, test := range []struct {
+		path        string
+		wantContent string
+		wantMode    os.FileMode
+	}{
+		{
+			path:        "demo/config",
+			wantContent: "hello demo",
+			wantMode:    0644,
+		},
+		{
+			path:        "demo/bin/run",
+			wantContent: "echo demo",
+			wantMode:    0755,
+		},
+		{
+			path:        "static.txt",
+			wantContent: "{{ not expanded }}",
+			wantMode:    0644,
+		},
+	} {
+		f := filepath.Join(dst, test.path)
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Errorf("after ProcessTree(...): os.ReadFile(%q) = _,%v, need nil error", f, err)
+			continue
+		}
+		if string(b) != test.wantContent {
+			t.Errorf("after ProcessTree(...): %v holds %q, want %q", test.path, string(b), test.wantContent)
+		}
+		if st, _ := os.Stat(f); st.Mode().Perm() != test.wantMode {
+			t.Errorf("after ProcessTree(...): %v has mode %v, want %v", test.path, st.Mode().Perm(), test.wantMode)
+		}
+	}
+}
+
+func TestProcessTreeWritesNothingOnError(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatalf("os.Mkdir(%q) = %v, need nil error", src, err)
+	}
+	writeFile(t, src, "a.tpl", "fine")
+	writeFile(t, src, "b.tpl", `{{ die "broken" }}`)
+
+	p := New(&Opts{AllowAliases: true})
+	err := p.ProcessTree(nil, src, dst)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("ProcessTree(...) = %v, want error with %q", err, "broken")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		t.Errorf("after failing ProcessTree(...): %v exists", dst)
+	}
+}
+
+func TestProcessTreeManagedBlock(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, d := range []string{src, dst} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("os.Mkdir(%q) = %v, need nil error", d, err)
+		}
+	}
+	writeFile(t, src, "{{ .Data.app }}.conf.tpl", "port {{ .Data.port }}")
+	writeFile(t, dst, "demo.conf", "# mine\n# BEGIN gtpl:gen\nold\n# END gtpl:gen\n")
+
+	// Names are expanded as they are, only the output of templates gets the managed block.
+	p := New(&Opts{AllowAliases: true, Values: []string{"app=demo", "port=22"}, ManagedBlock: "gen"})
+	if err := p.ProcessTree(nil, src, dst); err != nil {
+		t.Fatalf("ProcessTree(...) = %v, need nil error", err)
+	}
+	f := filepath.Join(dst, "demo.conf")
+	want := "# mine\n# BEGIN gtpl:gen\nport 22\n# END gtpl:gen\n"
+	if b, _ := os.ReadFile(f); string(b) != want {
+		t.Errorf("after ProcessTree(...): %v holds %q, want %q", f, string(b), want)
+	}
+}
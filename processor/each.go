@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/KarelKubat/gtpl/syringe"
+)
+
+// ProcessEach processes several cases that share a prelude, as if each case were processed together with the prelude
+// files, but in one go: the prelude is read and parsed once. Each case is executed with a fresh Syringe, so that no
+// state carries over from one case to the next. The output of a case goes to a file, which is named by expanding the
+// pattern (e.g. "{{ base }}.conf", see outputName). Nothing is written unless all cases succeed.
+func (p *Processor) ProcessEach(prelude, cases []string, pattern string) error {
+	return p.ProcessEachContext(context.Background(), prelude, cases, pattern)
+}
+
+// ProcessEachContext is ProcessEach that stops when a context is done.
+func (p *Processor) ProcessEachContext(ctx context.Context, prelude, cases []string, pattern string) error {
+	pre, err := readFiles(prelude)
+	if err != nil {
+		return err
+	}
+	p.inputs = append(append([]string(nil), prelude...), cases...)
+	for _, spec := range p.o.DataFiles {
+		p.inputs = append(p.inputs, dataFileName(spec))
+	}
+	data, err := loadData(p.o.DataFiles)
+	if err != nil {
+		return err
+	}
+
+	// Parse the prelude and load what it includes.
+	base, preParts, err := p.parse(template.New(preludeTemplate).Funcs(p.fmap), preludeTemplate, pre, nil, pre.stack())
+	if err != nil {
+		return preParts.fix(err)
+	}
+
+	var outputs []outputFile
+	for _, c := range cases {
+		path, err := outputName(pattern, c)
+		if err != nil {
+			return err
+		}
+		for _, o := range outputs {
+			if o.path == path {
+				return fmt.Errorf("%v: %v: output %v is already written", pattern, c, path)
+			}
+		}
+		var buf bytes.Buffer
+		r, err := p.executeCase(ctx, base, pre, preParts, c, data, &buf)
+		if err != nil {
+			return err
+		}
+		outputs = append(append(outputs, outputFile{
+			path:    path,
+			content: buf.Bytes(),
+		}), r.files...)
+	}
+	r := &router{
+		files: outputs,
+	}
+	return r.write()
+}
+
+// executeCase executes one case of ProcessEach, see compile.
+func (p *Processor) executeCase(ctx context.Context, base *template.Template, pre *source, preParts parts, file string,
+	data map[interface{}]interface{}, w io.Writer) (*router, error) {
+	c, err := p.compile(base, pre, preParts, []string{file})
+	if err != nil {
+		return nil, err
+	}
+	return p.executeCompiled(ctx, c, data, nil, w)
+}
+
+// compiled is template files that are parsed into a clone of a prelude. It can be executed repeatedly, also
+// concurrently, see executeCompiled.
+type compiled struct {
+	tpl      *template.Template // Main template, the nodes of the prelude precede the nodes of the files
+	pre      *source            // Prelude
+	src      *source            // Template files
+	preParts parts              // Parts of the prelude, for error messages
+	ps       parts              // Parts of the template files, for error messages
+}
+
+// compile parses template files into a clone of the prelude. The nodes of the prelude precede the nodes of the files,
+// so that variables that the prelude sets are available in the files.
+func (p *Processor) compile(base *template.Template, pre *source, preParts parts, files []string) (*compiled, error) {
+	src, err := readFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t, ps, err := p.parse(tpl, mainTemplate, src, appendVars(nil, base.Tree), append(pre.stack(), src.stack()...))
+	if err != nil {
+		return nil, ps.fix(err)
+	}
+	nodes := append([]parse.Node(nil), base.Tree.Root.Nodes...)
+	t.Tree.Root.Nodes = append(nodes, t.Tree.Root.Nodes...)
+	return &compiled{
+		tpl:      t,
+		pre:      pre,
+		src:      src,
+		preParts: preParts,
+		ps:       ps,
+	}, nil
+}
+
+// executeCompiled executes a compiled template with a fresh Syringe, so that no state carries over from one execution
+// to the next. The data is merged with the data of front matter and with extra data (e.g. of a request), then the
+// overrides of the options are applied.
+func (p *Processor) executeCompiled(ctx context.Context, c *compiled, data map[interface{}]interface{},
+	extra map[string]interface{}, w io.Writer) (*router, error) {
+	needle := syringe.New(p.o.syringeOpts())
+
+	// Builtins such as "setkeyval" change maps in place, so each execution gets its own copy of the data.
+	d := normalize(data).(map[interface{}]interface{})
+	c.pre.mergeData(d)
+	c.src.mergeData(d)
+	for k, v := range extra {
+		d[k] = normalize(v)
+	}
+	if err := setValues(d, p.o.Values); err != nil {
+		return nil, err
+	}
+	removeEmpty := c.src.removeEmptyLines(c.pre.removeEmptyLines(p.o.RemoveEmptyLines))
+	r, err := p.exec(ctx, c.tpl, needle, d, removeEmpty, w)
+	return r, c.preParts.fix(c.ps.fix(err))
+}
+
+// outputName expands an output pattern for a case file. Patterns use the delimiters {{ and }} and may call "base"
+// (the file name without directory and extension), "name" (the file name without directory) and "dir" (the directory
+// of the file).
+func outputName(pattern, file string) (string, error) {
+	name := filepath.Base(file)
+	tpl, err := template.New("outpattern").Funcs(template.FuncMap{
+		"base": func() string { return strings.TrimSuffix(name, filepath.Ext(name)) },
+		"name": func() string { return name },
+		"dir":  func() string { return filepath.Dir(file) },
+	}).Parse(pattern)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("%v: %v: output name is empty", pattern, file)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessEach(t *testing.T) {
+	dir := t.TempDir()
+	prelude := writeFile(t, dir, "common.tpl", `
+{{- $domain := "example.com" -}}
+{{- $counter := map "n" 0 -}}
+{{- define "header" }}# {{ . }}{{ end -}}`)
+	one := writeFile(t, dir, "one.tpl", `{{ template "header" "one" }}
+one.{{ $domain }}:{{ .Data.port }} {{ setkeyval $counter "n" 1 }}{{ getval $counter "n" }}`)
+	two := writeFile(t, dir, "two.tpl", `{{ template "header" "two" }}
+two.{{ $domain }}:{{ .Data.port }} {{ getval $counter "n" }}`)
+
+	p := New(&Opts{AllowAliases: true, Values: []string{"port=22"}})
+	if err := p.ProcessEach([]string{prelude}, []string{one, two}, "{{ dir }}/{{ base }}.conf"); err != nil {
+		t.Fatalf("ProcessEach(...) = %v, need nil error", err)
+	}
+	for _, test := range []struct {
+		path        string
+		wantContent string
+	}{
+		{
+			path:        "one.conf",
+			wantContent: "# one\none.example.com:22 1",
+		},
+		{
+			// The counter that one.tpl changed starts afresh.
+			path:        "two.conf",
+			wantContent: "# two\ntwo.example.com:22 0",
+		},
+	} {
+		f := filepath.Join(dir, test.path)
+		if b, _ := os.ReadFile(f); string(b) != test.wantContent {
+			t.Errorf("after ProcessEach(...): %v holds %q, want %q", test.path, string(b), test.wantContent)
+		}
+	}
+}
+
+func TestProcessEachErrors(t *testing.T) {
+	dir := t.TempDir()
+	prelude := writeFile(t, dir, "common.tpl", "{{ $n := 1 }}\n{{ $n.field }}")
+	for _, test := range []struct {
+		prelude   string
+		content   string
+		pattern   string
+		wantError string
+	}{
+		{
+			content:   "\n  {{ die \"broken\" }}",
+			pattern:   "{{ base }}.out",
+			wantError: "case.tpl:2:5: ",
+		},
+		{
+			content:   "{{ $n }}",
+			pattern:   "{{ base }}.out",
+			wantError: `case.tpl:1: undefined variable "$n"`,
+		},
+		{
+			prelude:   prelude,
+			content:   "{{ $n }}",
+			pattern:   "{{ base }}.out",
+			wantError: "common.tpl:2:5: ",
+		},
+		{
+			content:   "fine",
+			pattern:   "{{ nope }}",
+			wantError: `function "nope" not defined`,
+		},
+	} {
+		c := writeFile(t, dir, "case.tpl", test.content)
+		var prelude []string
+		if test.prelude != "" {
+			prelude = []string{test.prelude}
+		}
+		p := New(&Opts{AllowAliases: true})
+		err := p.ProcessEach(prelude, []string{c}, test.pattern)
+		if err == nil || !strings.Contains(err.Error(), test.wantError) {
+			t.Errorf("ProcessEach(%q, %q) = %v, want error with %q", test.content, test.pattern, err, test.wantError)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "case.out")); err == nil {
+			t.Errorf("ProcessEach(%q, %q) wrote output, want nothing written", test.content, test.pattern)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	for _, test := range []struct {
+		pattern   string
+		file      string
+		want      string
+		wantError bool
+	}{
+		{pattern: "{{ base }}.conf", file: "cases/one.tpl", want: "one.conf"},
+		{pattern: "{{ dir }}/{{ name }}.out", file: "cases/one.tpl", want: "cases/one.tpl.out"},
+		{pattern: "{{ if false }}x{{ end }}", file: "one.tpl", wantError: true},
+		{pattern: "{{ base ", file: "one.tpl", wantError: true},
+	} {
+		got, err := outputName(test.pattern, test.file)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("outputName(%q, %q) = _,%v, want error: %v", test.pattern, test.file, err, test.wantError)
+		}
+		if got != test.want {
+			t.Errorf("outputName(%q, %q) = %q, want %q", test.pattern, test.file, got, test.want)
+		}
+	}
+}
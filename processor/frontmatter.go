@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	frontMatterStart = "--- " + mainTemplate // Line that starts front matter, a plain "---" is a YAML document separator
+	frontMatterEnd   = "---"                 // Line that ends front matter
+)
+
+// delimsDirective matches a first line such as "{{/* gtpl:delims << >> */}}", which sets the delimiters of a file.
+var delimsDirective = regexp.MustCompile(`^\{\{-?\s*/\*\s*` + mainTemplate + `:delims\s+(\S+)\s+(\S+)\s*\*/\s*-?\}\}\s*$`)
+
+// frontMatter holds settings that a template file states at its start, between lines "--- gtpl" and "---". The
+// settings are YAML (or JSON, which is YAML too) and override the options of the processor for that file.
+type frontMatter struct {
+	Data             map[string]interface{} `yaml:"data"`               // Merged into .Data
+	Output           string                 `yaml:"output"`             // Output file when none is given, relative to the template file
+	LeftDelimiter    string                 `yaml:"left-delimiter"`     // Opening delimiter in this file
+	RightDelimiter   string                 `yaml:"right-delimiter"`    // Closing delimiter in this file
+	RemoveEmptyLines *bool                  `yaml:"remove-empty-lines"` // Whether to remove empty lines from the output
+}
+
+// splitFrontMatter takes front matter off the start of a file. It returns the front matter (nil when absent), the
+// rest of the file and the number of lines that the front matter spans. Unknown settings are errors, so that a file
+// that only looks like front matter isn't silently dropped.
+func splitFrontMatter(name string, b []byte) (*frontMatter, []byte, int, error) {
+	first, rest, found := bytes.Cut(b, []byte("\n"))
+	if !found || strings.Join(strings.Fields(string(first)), " ") != frontMatterStart {
+		return nil, b, 0, nil
+	}
+	lines := 1
+	var doc []byte
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		lines++
+		if string(bytes.TrimRight(line, "\r")) == frontMatterEnd {
+			fm := &frontMatter{}
+			dec := yaml.NewDecoder(bytes.NewReader(doc))
+			dec.KnownFields(true)
+			if err := dec.Decode(fm); err != nil && !errors.Is(err, io.EOF) {
+				return nil, nil, 0, fmt.Errorf("%v: front matter: %v", displayName(name), err)
+			}
+			if (fm.LeftDelimiter == "") != (fm.RightDelimiter == "") {
+				return nil, nil, 0, fmt.Errorf("%v: front matter: left-delimiter and right-delimiter go together",
+					displayName(name))
+			}
+			return fm, rest, lines, nil
+		}
+		doc = append(append(doc, line...), '\n')
+	}
+	return nil, nil, 0, fmt.Errorf("%v: front matter: no closing %q", displayName(name), frontMatterEnd)
+}
+
+// splitDirective takes a delimiter directive off the first line of a file. The directive is returned as front matter
+// that only states delimiters (nil when absent), along with the rest of the file and the number of lines taken off.
+func splitDirective(b []byte) (*frontMatter, []byte, int) {
+	first, rest, _ := bytes.Cut(b, []byte("\n"))
+	m := delimsDirective.FindSubmatch(first)
+	if m == nil {
+		return nil, b, 0
+	}
+	return &frontMatter{
+		LeftDelimiter:  string(m[1]),
+		RightDelimiter: string(m[2]),
+	}, rest, 1
+}
+
+// mergeData merges the data of the front matter of the files into data. Files that come later override earlier ones.
+func (src *source) mergeData(data map[interface{}]interface{}) {
+	for _, seg := range src.segments {
+		if seg.fm == nil {
+			continue
+		}
+		for k, v := range seg.fm.Data {
+			data[k] = normalize(v)
+		}
+	}
+}
+
+// output returns the output file that the front matter of the files states, or "" when none does. Relative names are
+// relative to the directory of the file. Files that come later override earlier ones.
+func (src *source) output() string {
+	out := ""
+	for _, seg := range src.segments {
+		switch {
+		case seg.fm == nil || seg.fm.Output == "":
+		case filepath.IsAbs(seg.fm.Output) || seg.name == stdinName:
+			out = seg.fm.Output
+		default:
+			out = filepath.Join(filepath.Dir(seg.name), seg.fm.Output)
+		}
+	}
+	return out
+}
+
+// removeEmptyLines returns whether empty lines are removed from the output: as the front matter of the files states,
+// otherwise as given by def. Files that come later override earlier ones.
+func (src *source) removeEmptyLines(def bool) bool {
+	for _, seg := range src.segments {
+		if seg.fm != nil && seg.fm.RemoveEmptyLines != nil {
+			def = *seg.fm.RemoveEmptyLines
+		}
+	}
+	return def
+}
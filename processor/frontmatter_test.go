@@ -0,0 +1,238 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	for _, test := range []struct {
+		content   string
+		wantFM    bool
+		wantText  string
+		wantLines int
+		wantError bool
+	}{
+		{
+			content:  "no front matter\n",
+			wantText: "no front matter\n",
+		},
+		{
+			content:  "---",
+			wantText: "---",
+		},
+		{
+			content:   "--- gtpl\noutput: x.conf\nleft-delimiter: <<\nright-delimiter: '>>'\n---\ntext\n",
+			wantFM:    true,
+			wantText:  "text\n",
+			wantLines: 5,
+		},
+		{
+			content:   "--- gtpl\r\n{\"data\": {\"a\": 1}}\r\n---\r\ntext",
+			wantFM:    true,
+			wantText:  "text",
+			wantLines: 3,
+		},
+		{
+			// A YAML document separator is not front matter.
+			content:  "---\nname: x",
+			wantText: "---\nname: x",
+		},
+		{
+			content:   "---  gtpl \n---\ntext",
+			wantFM:    true,
+			wantText:  "text",
+			wantLines: 2,
+		},
+		{
+			content:   "--- gtpl\noutput: x.conf\n",
+			wantError: true,
+		},
+		{
+			content:   "--- gtpl\noutput: x.conf\nkind: Service\n---\n",
+			wantError: true,
+		},
+		{
+			content:   "--- gtpl\noutput: [\n---\n",
+			wantError: true,
+		},
+		{
+			content:   "--- gtpl\nleft-delimiter: <<\n---\n",
+			wantError: true,
+		},
+	} {
+		fm, text, lines, err := splitFrontMatter("f.tpl", []byte(test.content))
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("splitFrontMatter(%q) = _,_,_,%v, want error: %v", test.content, err, test.wantError)
+		}
+		if err != nil {
+			continue
+		}
+		if (fm != nil) != test.wantFM {
+			t.Errorf("splitFrontMatter(%q) = %v,_,_,_, want front matter: %v", test.content, fm, test.wantFM)
+		}
+		if string(text) != test.wantText || lines != test.wantLines {
+			t.Errorf("splitFrontMatter(%q) = _,%q,%v,_, want %q,%v",
+				test.content, string(text), lines, test.wantText, test.wantLines)
+		}
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", `{{- $domain := "example.com" -}}{{- define "header" }}# {{ . }}{{ end -}}`)
+	helm := writeFile(t, dir, "helm.tpl", `--- gtpl
+left-delimiter: "<<"
+right-delimiter: ">>"
+output: out/helm.yaml
+remove-empty-lines: true
+data:
+  app: demo
+---
+<< template "header" .Data.app >>
+
+host: << .Data.app >>.<< $domain >>
+image: {{ .Values.image }}
+`)
+	want := "# demo\nhost: demo.example.com\nimage: {{ .Values.image }}\n"
+
+	// The output goes to the file that the front matter states, relative to the template.
+	p := New(&Opts{AllowAliases: true})
+	var buf bytes.Buffer
+	if err := p.ProcessFiles([]string{lib, helm}, &buf); err != nil {
+		t.Fatalf("ProcessFiles(...) = %v, need nil error", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("ProcessFiles(...) wrote %q, want nothing", buf.String())
+	}
+	out := filepath.Join(dir, "out", "helm.yaml")
+	if b, _ := os.ReadFile(out); string(b) != want {
+		t.Errorf("after ProcessFiles(...): %v holds %q, want %q", out, string(b), want)
+	}
+	if d, err := p.Compare([]string{lib, helm}, ""); err != nil || d != "" {
+		t.Errorf("Compare(...) = %q,%v, want \"\",nil", d, err)
+	}
+
+	// An output file that the caller states wins, values override the data of front matter.
+	p = New(&Opts{AllowAliases: true, Values: []string{"app=other"}})
+	other := filepath.Join(dir, "other.yaml")
+	if err := p.ProcessToFile([]string{lib, helm}, other); err != nil {
+		t.Fatalf("ProcessToFile(...) = %v, need nil error", err)
+	}
+	if b, _ := os.ReadFile(other); !strings.HasPrefix(string(b), "# other\n") {
+		t.Errorf("after ProcessToFile(...): %v holds %q, want the value of app overridden", other, string(b))
+	}
+}
+
+func TestYAMLDocuments(t *testing.T) {
+	// Output that starts with a YAML document separator is not taken for front matter.
+	tpl := "---\napiVersion: v1\nkind: {{ \"Service\" }}\n---\napiVersion: v1\nkind: Pod\n"
+	want := "---\napiVersion: v1\nkind: Service\n---\napiVersion: v1\nkind: Pod\n"
+	p := New(&Opts{})
+	var buf bytes.Buffer
+	if err := p.ProcessStreams(strings.NewReader(tpl), &buf); err != nil {
+		t.Fatalf("ProcessStreams(%q) = %v, need nil error", tpl, err)
+	}
+	if buf.String() != want {
+		t.Errorf("ProcessStreams(%q) wrote %q, want %q", tpl, buf.String(), want)
+	}
+}
+
+func TestFrontMatterErrorLocation(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", "{{ $x := 1 }}\n")
+	for _, test := range []struct {
+		content   string
+		wantError string
+	}{
+		{
+			content:   "--- gtpl\nremove-empty-lines: true\n---\n\n  {{ die \"stop\" }}",
+			wantError: "case.tpl:5:5: ",
+		},
+		{
+			content:   "--- gtpl\nleft-delimiter: <<\nright-delimiter: '>>'\n---\n<< $x >>\n  << die \"stop\" >>",
+			wantError: "case.tpl:6:5: ",
+		},
+		{
+			content:   "--- gtpl\nleft-delimiter: <<\nright-delimiter: '>>'\n---\n<< $x >> << if >>",
+			wantError: "case.tpl:5: missing value for if",
+		},
+		{
+			content:   "--- gtpl\nleft-delimiter: <<\nright-delimiter: '>>'\n---\n<< $y >>",
+			wantError: `case.tpl:5: undefined variable "$y"`,
+		},
+	} {
+		c := writeFile(t, dir, "case.tpl", test.content)
+		p := New(&Opts{AllowAliases: true})
+		err := p.ProcessFiles([]string{lib, c}, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.wantError) {
+			t.Errorf("ProcessFiles(%q) = %v, want error with %q", test.content, err, test.wantError)
+		}
+	}
+}
+
+func TestSplitDirective(t *testing.T) {
+	for _, test := range []struct {
+		content   string
+		wantLeft  string
+		wantRight string
+		wantText  string
+	}{
+		{
+			content:  "no directive\n",
+			wantText: "no directive\n",
+		},
+		{
+			content:  "\n{{/* gtpl:delims << >> */}}\n",
+			wantText: "\n{{/* gtpl:delims << >> */}}\n",
+		},
+		{
+			content:   "{{/* gtpl:delims << >> */}}\ntext\n",
+			wantLeft:  "<<",
+			wantRight: ">>",
+			wantText:  "text\n",
+		},
+		{
+			content:   "{{- /*gtpl:delims [[ ]]*/ -}}  \r\ntext",
+			wantLeft:  "[[",
+			wantRight: "]]",
+			wantText:  "text",
+		},
+	} {
+		fm, text, _ := splitDirective([]byte(test.content))
+		left, right := "", ""
+		if fm != nil {
+			left, right = fm.LeftDelimiter, fm.RightDelimiter
+		}
+		if left != test.wantLeft || right != test.wantRight || string(text) != test.wantText {
+			t.Errorf("splitDirective(%q) = %q %q,%q,_, want %q %q,%q",
+				test.content, left, right, string(text), test.wantLeft, test.wantRight, test.wantText)
+		}
+	}
+}
+
+func TestMixedDelimiters(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", `{{- $app := "demo" -}}{{- define "name" }}{{ . }}-chart{{ end -}}`)
+	chart := writeFile(t, dir, "chart.tpl", `{{/* gtpl:delims << >> */}}
+name: << template "name" $app >>
+image: {{ .Values.image }}
+  << die "stop" >>`)
+	p := New(&Opts{AllowAliases: true})
+	err := p.ProcessFiles([]string{lib, chart}, &bytes.Buffer{})
+	if want := chart + ":4:5: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("ProcessFiles(...) = %v, want error with prefix %q", err, want)
+	}
+
+	writeFile(t, dir, "chart.tpl", "{{/* gtpl:delims << >> */}}\nname: << template \"name\" $app >>\nimage: {{ .Values.image }}\n")
+	var buf bytes.Buffer
+	if err := p.ProcessFiles([]string{lib, chart}, &buf); err != nil {
+		t.Fatalf("ProcessFiles(...) = %v, need nil error", err)
+	}
+	if want := "name: demo-chart\nimage: {{ .Values.image }}\n"; buf.String() != want {
+		t.Errorf("ProcessFiles(...) wrote %q, want %q", buf.String(), want)
+	}
+}
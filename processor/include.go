@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	gtplPathEnv = "GTPL_PATH" // Environment variable with a search path for included files
+)
+
+// includer loads the files that templates pull in using "include" or "import", and expands them for "include".
+type includer struct {
+	p      *Processor
+	tpl    *template.Template // Template set that receives all loaded files
+	data   interface{}        // Default dot for included files
+	loaded parts              // Loaded files, for error messages
+	w      *limitedWriter     // Writer of the execution, or of the file being included; nil when loading
+}
+
+// load walks the parse tree of t and loads every file that is included or imported, recursively. Each file is parsed
+// as its own template in the set, named after its path, so that definitions are shared. Just as input files, loaded
+// files may state their delimiters in front matter or a directive (otherwise those of the processor apply), and hold
+// raw blocks. The file name argument in
+// the parse tree is rewritten to that path. fileAt returns the file holding a position in t; relative names are
+// resolved against its directory first. The stack holds the absolute paths of the files being loaded.
+func (inc *includer) load(t *template.Template, fileAt func(parse.Pos) string, stack []string) error {
+	var err error
+	walk(t.Tree.Root, func(cmd *parse.CommandNode) {
+		name := inc.p.builtinAt(cmd)
+		if err != nil || (name != "Include" && name != "Import") || len(cmd.Args) < 2 {
+			return
+		}
+		loc, _ := t.ErrorContext(cmd)
+		arg, ok := cmd.Args[1].(*parse.StringNode)
+		if !ok {
+			err = fmt.Errorf("%v: %v: the file name must be a string constant", loc, strings.ToLower(name))
+			return
+		}
+		path, e := inc.resolve(arg.Text, fileAt(cmd.Position()))
+		if e != nil {
+			err = fmt.Errorf("%v: %v: %v", loc, strings.ToLower(name), e)
+			return
+		}
+		abs, e := filepath.Abs(path)
+		if e != nil {
+			err = e
+			return
+		}
+		for _, s := range stack {
+			if s == abs {
+				err = fmt.Errorf("%v: %v: %v: include cycle", loc, strings.ToLower(name), path)
+				return
+			}
+		}
+		arg.Text = path
+		arg.Quoted = strconv.Quote(path)
+		if inc.tpl.Lookup(path) != nil {
+			return
+		}
+		inc.p.inputs = append(inc.p.inputs, path)
+		b, e := os.ReadFile(path)
+		if e != nil {
+			err = e
+			return
+		}
+		src := &source{}
+		if e := src.add(path, b); e != nil {
+			err = e
+			return
+		}
+		pt := &part{
+			tmpl:     path,
+			src:      src,
+			included: true,
+		}
+		inc.loaded = append(inc.loaded, pt)
+		left, right := inc.p.delims(src.segments[0])
+		text, offset, e := expandRaw(src.text, left, right)
+		if e != nil {
+			err = fmt.Errorf("%v: %v", src.location(offset), e)
+			return
+		}
+		nt, e := inc.tpl.New(path).Delims(left, right).Parse(text)
+		if e != nil {
+			err = parts{pt}.fix(e)
+			return
+		}
+		if e := inc.p.permitted(inc.tpl); e != nil {
+			err = e
+			return
+		}
+		err = inc.load(nt, func(parse.Pos) string { return path }, append(stack, abs))
+	})
+	return err
+}
+
+// resolve finds an included file: relative to the including file, then in the search path.
+func (inc *includer) resolve(name, from string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	dirs := append([]string{filepath.Dir(from)}, inc.p.o.IncludePath...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv(gtplPathEnv))...)
+	for _, dir := range dirs {
+		f := filepath.Join(dir, name)
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%v: not found in %v", name, strings.Join(dirs, ", "))
+}
+
+// Include satisfies syringe.Includer, it expands a loaded file. Expanding fails when the output exceeds the limits of
+// the execution, or when the context is done.
+func (inc *includer) Include(name string, data ...interface{}) (string, error) {
+	t := inc.tpl.Lookup(name)
+	if t == nil {
+		return "", fmt.Errorf("%v: not loaded", name)
+	}
+	dot := inc.data
+	if len(data) > 0 {
+		dot = data[0]
+	}
+	// The file is rendered within the limits of the execution, its output counts until it is written.
+	var buf bytes.Buffer
+	outer := inc.w
+	inc.w = &limitedWriter{
+		w:      &buf,
+		ctx:    outer.ctx,
+		limits: outer.limits,
+		outer:  outer,
+	}
+	defer func() { inc.w = outer }()
+	err := t.Execute(inc.w, dot)
+	return buf.String(), err
+}
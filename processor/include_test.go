@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	libDir := filepath.Join(dir, "lib")
+	pathDir := filepath.Join(dir, "path")
+	envDir := filepath.Join(dir, "env")
+	for _, d := range []string{libDir, pathDir, envDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("os.Mkdir(%q) = %v, need nil error", d, err)
+		}
+	}
+	writeFile(t, libDir, "host.tpl", `{{ import "defs.tpl" }}Host {{ template "name" . }}`)
+	writeFile(t, libDir, "defs.tpl", `{{ define "name" }}{{ getval . "hostname" }}{{ end }}`)
+	writeFile(t, pathDir, "frompath.tpl", `frompath`)
+	writeFile(t, envDir, "fromenv.tpl", `{{ define "fromenv" }}fromenv{{ end }}`)
+	main := writeFile(t, dir, "main.tpl", strings.Join([]string{
+		`{{ include "lib/host.tpl" (map "hostname" "ws") }}`,
+		`{{ include "frompath.tpl" }}`,
+		`{{ import "fromenv.tpl" }}{{ template "fromenv" }}`,
+		`{{ template "name" (map "hostname" "pi") }}`,
+	}, "\n"))
+
+	t.Setenv(gtplPathEnv, envDir)
+	wr := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, IncludePath: []string{pathDir}})
+	if err := p.ProcessFiles([]string{main}, wr); err != nil {
+		t.Fatalf("ProcessFiles(...) = %v, need nil error", err)
+	}
+	want := "Host ws\nfrompath\nfromenv\npi"
+	if wr.String() != want {
+		t.Errorf("ProcessFiles(...): output is %q, want %q", wr.String(), want)
+	}
+}
+
+func TestIncludeDelimiters(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "lib.tpl", `<< define "x" >>x<< end >>{{ not expanded }}`)
+	main := writeFile(t, dir, "main.tpl", `<< import "lib.tpl" >><< template "x" >> << include "lib.tpl" >>`)
+	wr := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, LeftDelimiter: "<<", RightDelimter: ">>"})
+	if err := p.ProcessFiles([]string{main}, wr); err != nil {
+		t.Fatalf("ProcessFiles(...) = %v, need nil error", err)
+	}
+	if want := "x {{ not expanded }}"; wr.String() != want {
+		t.Errorf("ProcessFiles(...): output is %q, want %q", wr.String(), want)
+	}
+}
+
+func TestIncludeDirective(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", "{{/* gtpl:delims << >> */}}\n<< define \"x\" >>x<< end >>{{ y }}\n  << die \"stop\" >>")
+	for _, test := range []struct {
+		main      string
+		want      string
+		wantError string
+	}{
+		{
+			main: `{{ import "lib.tpl" }}{{ template "x" }}`,
+			want: "x",
+		},
+		{
+			main:      `{{ include "lib.tpl" }}`,
+			wantError: lib + ":3:5: ",
+		},
+	} {
+		main := writeFile(t, dir, "main.tpl", test.main)
+		wr := &bytes.Buffer{}
+		p := New(&Opts{AllowAliases: true})
+		err := p.ProcessFiles([]string{main}, wr)
+		switch {
+		case test.wantError != "" && (err == nil || !strings.Contains(err.Error(), test.wantError)):
+			t.Errorf("ProcessFiles(%q) = %v, want error with %q", test.main, err, test.wantError)
+		case test.wantError == "" && err != nil:
+			t.Errorf("ProcessFiles(%q) = %v, need nil error", test.main, err)
+		case test.wantError == "" && wr.String() != test.want:
+			t.Errorf("ProcessFiles(%q): output is %q, want %q", test.main, wr.String(), test.want)
+		}
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.tpl", `{{ include "b.tpl" }}`)
+	writeFile(t, dir, "b.tpl", `{{ include "a.tpl" }}`)
+	writeFile(t, dir, "self.tpl", `{{ import "self.tpl" }}`)
+	writeFile(t, dir, "missing.tpl", `{{ include "nonexisting.tpl" }}`)
+	writeFile(t, dir, "variable.tpl", `{{ $f := "a.tpl" }}{{ include $f }}`)
+
+	for _, test := range []struct {
+		file      string
+		wantError string
+	}{
+		{
+			file:      "a.tpl",
+			wantError: "include cycle",
+		},
+		{
+			file:      "self.tpl",
+			wantError: "include cycle",
+		},
+		{
+			file:      "missing.tpl",
+			wantError: "not found",
+		},
+		{
+			file:      "variable.tpl",
+			wantError: "must be a string constant",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true})
+		err := p.ProcessFiles([]string{filepath.Join(dir, test.file)}, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.wantError) {
+			t.Errorf("ProcessFiles(%q) = %v, want error with %q", test.file, err, test.wantError)
+		}
+	}
+}
+
+func TestIncludeLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "big.tpl", `{{ range loop 0 100 }}0123456789{{ end }}`)
+	writeFile(t, dir, "dropped.tpl", `{{ $out := include "big.tpl" }}`)
+	writeFile(t, dir, "nested.tpl", `{{ $out := include "dropped.tpl" }}`)
+	for _, file := range []string{"dropped.tpl", "nested.tpl"} {
+		p := New(&Opts{AllowAliases: true, Limits: Limits{MaxOutput: 50}})
+		err := p.ProcessFiles([]string{filepath.Join(dir, file)}, &bytes.Buffer{})
+		if want := "output exceeds the limit of 50 bytes"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ProcessFiles(%q) = %v, want error with %q", file, err, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"reflect"
+	"text/template"
+	"text/template/parse"
+)
+
+// runningFunc is the function that calls of templates pass their dot through, see checkTemplates. It isn't a builtin:
+// it's added when executing, so templates can't call it by name.
+const runningFunc = "running"
+
+// checkTemplates rewrites the calls of templates in a list (and in the lists that it holds) so that they stop when
+// execution must stop, also when they write nothing (e.g. recursion): `{{ template "x" . }}` becomes
+// `{{ template "x" (running .) }}`. Calls that are already rewritten are left alone.
+func (p *Processor) checkTemplates(t *template.Template, l *parse.ListNode) {
+	if l == nil {
+		return
+	}
+	for _, n := range l.Nodes {
+		switch n := n.(type) {
+		case *parse.IfNode:
+			p.checkTemplates(t, n.List)
+			p.checkTemplates(t, n.ElseList)
+		case *parse.RangeNode:
+			p.checkTemplates(t, n.List)
+			p.checkTemplates(t, n.ElseList)
+		case *parse.WithNode:
+			p.checkTemplates(t, n.List)
+			p.checkTemplates(t, n.ElseList)
+		case *parse.TemplateNode:
+			if calls(n.Pipe, runningFunc) {
+				continue
+			}
+			p.rewritten.add(n, 0)
+			pos := n.Position()
+			cmd := &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      pos,
+				Args:     []parse.Node{parse.NewIdentifier(runningFunc).SetTree(t.Tree).SetPos(pos)},
+			}
+			if n.Pipe != nil {
+				cmd.Args = append(cmd.Args, n.Pipe)
+			}
+			n.Pipe = &parse.PipeNode{
+				NodeType: parse.NodePipe,
+				Pos:      pos,
+				Line:     n.Line,
+				Cmds:     []*parse.CommandNode{cmd},
+			}
+		}
+	}
+}
+
+// calls returns whether a call of a template passes its dot through a function, also when checkTemplates and
+// traceTemplates both wrapped it: e.g. `running (traceenter "file:1:5" "x" .)` passes it through both.
+func calls(pipe *parse.PipeNode, fn string) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 {
+		return false
+	}
+	args := pipe.Cmds[0].Args
+	id, ok := args[0].(*parse.IdentifierNode)
+	switch {
+	case !ok:
+		return false
+	case id.Ident == fn:
+		return true
+	case id.Ident != runningFunc && id.Ident != "traceenter":
+		return false
+	}
+	inner, ok := args[len(args)-1].(*parse.PipeNode)
+	return ok && calls(inner, fn)
+}
+
+// runningFuncs returns the functions for an execution that check whether it must stop: the builtins of fmap, which
+// fail before they are called, and runningFunc, which returns the dot that it gets.
+func runningFuncs(l *limitedWriter, fmap template.FuncMap) template.FuncMap {
+	out := template.FuncMap{
+		runningFunc: func(dot ...interface{}) (interface{}, error) {
+			if err := l.err(); err != nil {
+				return nil, err
+			}
+			if len(dot) == 0 {
+				return nil, nil
+			}
+			return dot[0], nil
+		},
+	}
+	for name, f := range fmap {
+		fv := reflect.ValueOf(f)
+		out[name] = reflect.MakeFunc(fv.Type(), func(args []reflect.Value) []reflect.Value {
+			// text/template turns panics of functions into errors.
+			if err := l.err(); err != nil {
+				panic(err)
+			}
+			if fv.Type().IsVariadic() {
+				return fv.CallSlice(args)
+			}
+			return fv.Call(args)
+		}).Interface()
+	}
+	return out
+}
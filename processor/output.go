@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// replaceFile atomically replaces a file by new content: the content goes into a temporary file in the same
+// directory, which is synced and then renamed. When the file already holds this content, it is left untouched (and
+// keeps its modification time). The mode of an existing file is preserved, new files get mode 0644. When the path is a
+// symlink, the file that it points to is replaced and the link stays.
+func replaceFile(path string, content []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode().Perm()
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, content) {
+			return nil
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// Get rid of the temporary file if anything fails. After a successful rename this is a no-op.
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// limitedWriter is the writer for template execution, it enforces the limits of the options. Writing fails when the
+// output gets too large, or when the context is done.
+type limitedWriter struct {
+	w       io.Writer
+	ctx     context.Context
+	limits  *Limits
+	written int            // Bytes written so far
+	outer   *limitedWriter // Writer that the output goes to later (e.g. for included files), nil when none
+}
+
+// Write writes to the underlying writer, unless that exceeds a limit.
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if err := l.err(); err != nil {
+		return 0, err
+	}
+	if l.limits.MaxOutput > 0 && l.total()+len(b) > l.limits.MaxOutput {
+		return 0, fmt.Errorf("output exceeds the limit of %v bytes", l.limits.MaxOutput)
+	}
+	n, err := l.w.Write(b)
+	l.written += n
+	return n, err
+}
+
+// total returns the bytes written so far, including those of the outer writers.
+func (l *limitedWriter) total() int {
+	if l.outer == nil {
+		return l.written
+	}
+	return l.written + l.outer.total()
+}
+
+// err returns why execution must stop because the context is done, or nil. When the context was cancelled with a
+// cause (e.g. when aborting at a breakpoint), that's the error.
+func (l *limitedWriter) err() error {
+	err := l.ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) && l.limits.Timeout > 0 {
+		return fmt.Errorf("execution exceeds the timeout of %v", l.limits.Timeout)
+	}
+	if cause := context.Cause(l.ctx); !errors.Is(cause, err) {
+		return cause
+	}
+	return err
+}
+
+// outputFile is the output for a separate file.
+type outputFile struct {
+	path    string
+	content []byte
+}
+
+// router is the writer for template execution, it satisfies syringe.Router. Output goes to the main writer, except
+// between "file" and "endfile", where it's collected for a separate file in the output directory.
+type router struct {
+	p                *Processor
+	main             io.Writer    // Writer for the main output
+	removeEmptyLines bool         // When true, remove empty lines from collected files
+	name             string       // File being collected, "" when writing to the main writer
+	buf              bytes.Buffer // Output for the file being collected
+	files            []outputFile // Collected files
+}
+
+// Write sends output to the main writer or to the file being collected.
+func (r *router) Write(b []byte) (int, error) {
+	if r.name == "" {
+		return r.main.Write(b)
+	}
+	return r.buf.Write(b)
+}
+
+// Begin starts collecting output for a file, relative to the output directory.
+func (r *router) Begin(name string) error {
+	if r.name != "" {
+		return fmt.Errorf("%v: cannot be nested, %v is still open", name, r.name)
+	}
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("%v: must be a relative name inside the output directory", name)
+	}
+	dir := r.p.o.OutputDir
+	if dir == "" {
+		dir = "."
+	}
+	r.name = filepath.Join(dir, name)
+	for _, f := range r.files {
+		if f.path == r.name {
+			r.name = ""
+			return fmt.Errorf("%v: already written", name)
+		}
+	}
+	return nil
+}
+
+// End stops collecting output for a file.
+func (r *router) End() error {
+	if r.name == "" {
+		return errors.New("no file is open")
+	}
+	content := append([]byte(nil), r.buf.Bytes()...)
+	if r.removeEmptyLines {
+		content = removeEmptyLines(content)
+	}
+	r.files = append(r.files, outputFile{
+		path:    r.name,
+		content: content,
+	})
+	r.name = ""
+	r.buf.Reset()
+	return nil
+}
+
+// finish checks that all files are ended.
+func (r *router) finish() error {
+	if r.name != "" {
+		return fmt.Errorf("file: %v: missing endfile", r.name)
+	}
+	return nil
+}
+
+// write writes the collected files, creating directories as needed.
+func (r *router) write() error {
+	for _, f := range r.files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		if err := replaceFile(f.path, f.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
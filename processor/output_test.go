@@ -0,0 +1,247 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.conf")
+
+	// New files are created.
+	if err := replaceFile(path, []byte("one\n")); err != nil {
+		t.Fatalf("replaceFile(%q, ...) = %v, need nil error", path, err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "one\n" {
+		t.Errorf("after replaceFile(%q, one): content is %q", path, string(b))
+	}
+
+	// Unchanged content leaves the file alone.
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("os.Chtimes(%q) = %v, need nil error", path, err)
+	}
+	if err := replaceFile(path, []byte("one\n")); err != nil {
+		t.Fatalf("replaceFile(%q, ...) = %v, need nil error", path, err)
+	}
+	if st, _ := os.Stat(path); !st.ModTime().Equal(past) {
+		t.Errorf("after replaceFile(%q) with the same content: mtime is %v, want %v", path, st.ModTime(), past)
+	}
+
+	// Changed content replaces the file, its mode is kept.
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatalf("os.Chmod(%q) = %v, need nil error", path, err)
+	}
+	if err := replaceFile(path, []byte("two\n")); err != nil {
+		t.Fatalf("replaceFile(%q, ...) = %v, need nil error", path, err)
+	}
+	st, _ := os.Stat(path)
+	if b, _ := os.ReadFile(path); string(b) != "two\n" {
+		t.Errorf("after replaceFile(%q, two): content is %q", path, string(b))
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("after replaceFile(%q, two): mode is %v, want 0600", path, st.Mode().Perm())
+	}
+
+	// No temporary files are left behind.
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("after replaceFile(...): %v files in %v, want 1", len(entries), dir)
+	}
+}
+
+func TestReplaceFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := filepath.Join(dir, "real")
+	if err := os.Mkdir(targetDir, 0755); err != nil {
+		t.Fatalf("os.Mkdir(%q) = %v, need nil error", targetDir, err)
+	}
+	target := filepath.Join(targetDir, "out.conf")
+	if err := os.WriteFile(target, []byte("one\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v, need nil error", target, err)
+	}
+	link := filepath.Join(dir, "link.conf")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("os.Symlink(%q, %q) = %v, symlinks are not supported", target, link, err)
+	}
+
+	// The link stays, the file that it points to gets the content.
+	if err := replaceFile(link, []byte("two\n")); err != nil {
+		t.Fatalf("replaceFile(%q, ...) = %v, need nil error", link, err)
+	}
+	if st, err := os.Lstat(link); err != nil || st.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("after replaceFile(%q, two): not a symlink anymore", link)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "two\n" {
+		t.Errorf("after replaceFile(%q, two): content of %q is %q", link, target, string(b))
+	}
+
+	// The temporary file goes into the directory of the target, so no files are left next to the link.
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("after replaceFile(%q, ...): %v files in %v, want 2", link, len(entries), dir)
+	}
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 1 {
+		t.Errorf("after replaceFile(%q, ...): %v files in %v, want 1", link, len(entries), targetDir)
+	}
+}
+func TestProcessToFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "out.conf", "previous\n")
+	good := writeFile(t, dir, "good.tpl", "{{ $x := 42 }}\n\nanswer {{ $x }}\n")
+	bad := writeFile(t, dir, "bad.tpl", "partial\n{{ die \"stop\" }}\n")
+
+	for _, test := range []struct {
+		file        string
+		wantError   bool
+		wantContent string
+	}{
+		{
+			file:        bad,
+			wantError:   true,
+			wantContent: "previous\n",
+		},
+		{
+			file:        good,
+			wantError:   false,
+			wantContent: "answer 42\n",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true, RemoveEmptyLines: true})
+		err := p.ProcessToFile([]string{test.file}, path)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("ProcessToFile(%q, ...) = %v, want error: %v", test.file, err, test.wantError)
+		}
+		if b, _ := os.ReadFile(path); string(b) != test.wantContent {
+			t.Errorf("after ProcessToFile(%q, ...): content is %q, want %q", test.file, string(b), test.wantContent)
+		}
+	}
+}
+
+func TestRemoveEmptyLinesWritesNothingOnError(t *testing.T) {
+	wr := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, RemoveEmptyLines: true})
+	if err := p.ProcessStreams(strings.NewReader("partial\n{{ die \"stop\" }}\n"), wr); err == nil {
+		t.Fatal("ProcessStreams(...) = nil, want error")
+	}
+	if wr.Len() != 0 {
+		t.Errorf("ProcessStreams(...) wrote %q, want nothing", wr.String())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	tpl := writeFile(t, dir, "in.tpl", "{{ $x := 42 }}answer {{ $x }}\n")
+	upToDate := writeFile(t, dir, "uptodate.conf", "answer 42\n")
+	outdated := writeFile(t, dir, "outdated.conf", "answer 41\n")
+	missing := filepath.Join(dir, "missing.conf")
+
+	for _, test := range []struct {
+		path     string
+		wantDiff string
+	}{
+		{
+			path:     upToDate,
+			wantDiff: "",
+		},
+		{
+			path:     outdated,
+			wantDiff: "-answer 41\n+answer 42\n",
+		},
+		{
+			path:     missing,
+			wantDiff: "+answer 42\n",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true})
+		d, err := p.Compare([]string{tpl}, test.path)
+		if err != nil {
+			t.Fatalf("Compare(..., %q) = _,%v, need nil error", test.path, err)
+		}
+		if !strings.HasSuffix(d, test.wantDiff) || (test.wantDiff == "" && d != "") {
+			t.Errorf("Compare(..., %q) = %q, want a diff ending in %q", test.path, d, test.wantDiff)
+		}
+	}
+	// Comparing doesn't write.
+	if b, _ := os.ReadFile(outdated); string(b) != "answer 41\n" {
+		t.Errorf("after Compare(..., %q): content is %q, want it unchanged", outdated, string(b))
+	}
+	if _, err := os.Stat(missing); err == nil {
+		t.Errorf("after Compare(..., %q): file exists, want it not created", missing)
+	}
+}
+
+func TestFileBlocks(t *testing.T) {
+	dir := t.TempDir()
+	tpl := `main
+{{ range $h := list "ws" "pi" }}{{ file (strcat "hosts/" $h ".conf") }}
+Host {{ $h }}
+{{ endfile }}{{ end }}end`
+	wr := &bytes.Buffer{}
+	p := New(&Opts{AllowAliases: true, RemoveEmptyLines: true, OutputDir: dir})
+	if err := p.ProcessStreams(strings.NewReader(tpl), wr); err != nil {
+		t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+	}
+	if want := "main\nend\n"; wr.String() != want {
+		t.Errorf("ProcessStreams(...): output is %q, want %q", wr.String(), want)
+	}
+	for _, h := range []string{"ws", "pi"} {
+		f := filepath.Join(dir, "hosts", h+".conf")
+		if b, _ := os.ReadFile(f); string(b) != "Host "+h+"\n" {
+			t.Errorf("ProcessStreams(...): %v holds %q, want %q", f, string(b), "Host "+h+"\n")
+		}
+	}
+
+	// Compare reports changes in separate files.
+	main := writeFile(t, dir, "main.tpl", tpl)
+	out := writeFile(t, dir, "out", "main\nend\n")
+	writeFile(t, filepath.Join(dir, "hosts"), "pi.conf", "Host raspberry\n")
+	d, err := p.Compare([]string{main}, out)
+	if err != nil {
+		t.Fatalf("Compare(...) = _,%v, need nil error", err)
+	}
+	if !strings.Contains(d, "-Host raspberry\n+Host pi\n") || strings.Contains(d, "ws.conf") {
+		t.Errorf("Compare(...) = %q, want only a diff for pi.conf", d)
+	}
+}
+
+func TestFileBlockErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		tpl       string
+		wantError string
+	}{
+		{
+			tpl:       `{{ file "a" }}{{ file "b" }}{{ endfile }}{{ endfile }}`,
+			wantError: "cannot be nested",
+		},
+		{
+			tpl:       `{{ endfile }}`,
+			wantError: "no file is open",
+		},
+		{
+			tpl:       `{{ file "a" }}`,
+			wantError: "missing endfile",
+		},
+		{
+			tpl:       `{{ file "../a" }}{{ endfile }}`,
+			wantError: "inside the output directory",
+		},
+		{
+			tpl:       `{{ file "a" }}{{ endfile }}{{ file "a" }}{{ endfile }}`,
+			wantError: "already written",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true, OutputDir: dir})
+		err := p.ProcessStreams(strings.NewReader(test.tpl), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.wantError) {
+			t.Errorf("ProcessStreams(%q) = %v, want error with %q", test.tpl, err, test.wantError)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("after failing runs: %v files in %v, want none", len(entries), dir)
+	}
+}
@@ -0,0 +1,298 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// positioned are the builtins that have a variant which gets the position of the call, e.g. "CheckAt". The value
+// states whether that's only needed when keeping going: otherwise "assert" and "die" stop execution, and text/template
+// states the position.
+var positioned = map[string]bool{
+	"Assert": true,
+	"Die":    true,
+	"Check":  false,
+	"Warn":   false,
+}
+
+// strictVariants are the builtins that have a variant which fails instead of returning "", for strict mode.
+var strictVariants = map[string]string{
+	"GetVal": "GetValStrict",
+	"Env":    "EnvStrict",
+}
+
+// walk calls fn for every command in the parse tree below n, including commands in nested pipelines.
+func walk(n parse.Node, fn func(*parse.CommandNode)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, sub := range n.Nodes {
+			walk(sub, fn)
+		}
+	case *parse.ActionNode:
+		walk(n.Pipe, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walk(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walk(cmd, fn)
+		}
+	case *parse.CommandNode:
+		fn(n)
+		for _, arg := range n.Args {
+			walk(arg, fn)
+		}
+	case *parse.ChainNode:
+		walk(n.Node, fn)
+	}
+}
+
+// walkBranch walks the parts of an if, range or with.
+func walkBranch(b *parse.BranchNode, fn func(*parse.CommandNode)) {
+	walk(b.Pipe, fn)
+	walk(b.List, fn)
+	walk(b.ElseList, fn)
+}
+
+// builtinAt returns the name of the builtin that a command invokes (e.g. "Include" for both `include` and
+// `.Gtpl.Include`), or "" when the command doesn't invoke a builtin.
+func (p *Processor) builtinAt(cmd *parse.CommandNode) string {
+	switch n := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		if p.o.AllowAliases {
+			return p.names[n.Ident]
+		}
+	case *parse.FieldNode:
+		if len(n.Ident) == 2 && "."+n.Ident[0] == gtplNamePrefix {
+			for _, name := range p.names {
+				if name == n.Ident[1] {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// rewriteCalls rewrites calls of builtins in all templates of a set, to calls of their variants:
+//   - Builtins that report their position (see positioned) get it as first argument, e.g. "check" becomes "checkat".
+//     The parts map positions to input files.
+//   - In strict mode, builtins that return "" when something is missing are replaced by variants that fail.
+//   - When the iterations of "loop" are limited, it is replaced by "looplimited", which fails instead of recording a
+//     failure.
+//   - When tracing or profiling, calls of builtins and templates are wrapped in calls that log or measure them, see
+//     traceCall and traceTemplates.
+//   - Calls of templates pass their dot through a function that fails when execution must stop, see checkTemplates.
+//
+// The rewritten nodes are added to the rewrites of the processor, so that errors state them as written.
+func (p *Processor) rewriteCalls(tpl *template.Template, ps parts) {
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if p.o.tracing() {
+			p.traceTemplates(t, ps, t.Tree.Root)
+		}
+		p.checkTemplates(t, t.Tree.Root)
+		walk(t.Tree.Root, func(cmd *parse.CommandNode) {
+			name := p.builtinAt(cmd)
+			if name == "" {
+				return
+			}
+			call := cmd.Args[0].String()
+			variant, at := p.variant(name)
+			traced := p.o.tracing() && !untraced[name]
+			if variant != name || traced {
+				added := 0
+				if at && !traced {
+					added = 1
+				}
+				p.rewritten.add(cmd, added)
+				p.rewritten.add(cmd.Args[0], added)
+			}
+			p.call(cmd, variant)
+			if !at && !traced {
+				return
+			}
+			loc, _ := t.ErrorContext(cmd)
+			loc = ps.fix(errors.New(loc)).Error()
+			if traced {
+				p.traceCall(t, cmd, loc, call, variant)
+				return
+			}
+			cmd.Args = append([]parse.Node{cmd.Args[0], &parse.StringNode{
+				NodeType: parse.NodeString,
+				Pos:      cmd.Position(),
+				Quoted:   strconv.Quote(loc),
+				Text:     loc,
+			}}, cmd.Args[1:]...)
+		})
+	}
+}
+
+// variant returns the builtin that calls of a builtin are rewritten to, and whether it gets the position of the call.
+func (p *Processor) variant(name string) (string, bool) {
+	if variant, ok := strictVariants[name]; ok && p.o.Strict {
+		return variant, false
+	}
+	if name == "Loop" && p.o.Limits.MaxLoop > 0 {
+		return "LoopLimited", false
+	}
+	if keepGoing, ok := positioned[name]; ok && (!keepGoing || p.o.KeepGoing) {
+		return name + "At", true
+	}
+	return name, false
+}
+
+// call changes the builtin that a command invokes. An alias is replaced by an alias, a full name by a full name.
+func (p *Processor) call(cmd *parse.CommandNode, name string) {
+	switch n := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		for alias, other := range p.names {
+			if other == name {
+				n.Ident = alias
+			}
+		}
+	case *parse.FieldNode:
+		n.Ident[1] = name
+	}
+}
+
+// permitted checks that the templates of a set only call builtins that the sandbox permits. This is checked before
+// included files are loaded, so that a denied "include" reads nothing.
+func (p *Processor) permitted(tpl *template.Template) error {
+	var err error
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		walk(t.Tree.Root, func(cmd *parse.CommandNode) {
+			if err != nil {
+				return
+			}
+			if e := p.needle.Permitted(p.builtinAt(cmd)); e != nil {
+				loc, _ := t.ErrorContext(cmd)
+				err = fmt.Errorf("%v: %v", loc, e)
+			}
+		})
+	}
+	return err
+}
+
+// rewrite is a node of a parse tree, as it was written before rewriteCalls (or addBreakpoints) changed it.
+type rewrite struct {
+	text string // The node as written
+	call string // The builtin as errors name calls of it, e.g. "getval" or "GetVal"; "" when the node isn't a call
+	args int    // Number of arguments that the rewrite adds, such as the position
+}
+
+// rewrites map the nodes that are rewritten while parsing back to how they were written. Execution errors state nodes as
+// they are executed, such as `at <checkat "a.tpl:1:3" $ok>: ...`, fix states them as written. A processor may parse
+// and execute concurrently (e.g. for a server), so access is locked.
+type rewrites struct {
+	mu      sync.Mutex
+	pending map[parse.Node]rewrite // Nodes that are being rewritten
+	texts   map[string]rewrite     // By the text of the rewritten node
+}
+
+// newRewrites returns empty rewrites.
+func newRewrites() *rewrites {
+	return &rewrites{
+		pending: map[parse.Node]rewrite{},
+		texts:   map[string]rewrite{},
+	}
+}
+
+// add remembers a node before it's rewritten, adding a number of arguments. Nodes that are rewritten more than once keep
+// their first text.
+func (rw *rewrites) add(n parse.Node, args int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if _, ok := rw.pending[n]; ok {
+		return
+	}
+	r := rewrite{
+		text: n.String(),
+		args: args,
+	}
+	called := n
+	if cmd, ok := n.(*parse.CommandNode); ok {
+		called = cmd.Args[0]
+	}
+	switch c := called.(type) {
+	case *parse.IdentifierNode:
+		r.call = c.Ident
+	case *parse.FieldNode:
+		r.call = c.Ident[len(c.Ident)-1]
+	}
+	rw.pending[n] = r
+}
+
+// commit maps the texts of the rewritten nodes to the remembered ones, once rewriting is done.
+func (rw *rewrites) commit() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for n, r := range rw.pending {
+		if text := n.String(); text != r.text {
+			rw.texts[text] = r
+		}
+	}
+	rw.pending = map[parse.Node]rewrite{}
+}
+
+// argCount matches the numbers of arguments in an error of text/template.
+var argCount = regexp.MustCompile(`^ want (at least )?(\d+) got (\d+)`)
+
+// fix rewrites the nodes that an error states to how they were written. Calls of variants are named after the builtin
+// that was called, and the numbers of arguments exclude those that rewriting adds.
+func (rw *rewrites) fix(err error) error {
+	if err == nil {
+		return nil
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	msg := err.Error()
+	for text, r := range rw.texts {
+		at := "at <" + text + ">: "
+		i := strings.Index(msg, at)
+		if i < 0 {
+			continue
+		}
+		rest := msg[i+len(at):]
+		for _, prefix := range []string{"error calling ", "wrong number of args for "} {
+			after, ok := strings.CutPrefix(rest, prefix)
+			if !ok || r.call == "" {
+				continue
+			}
+			_, tail, _ := strings.Cut(after, ":")
+			if m := argCount.FindStringSubmatch(tail); m != nil && r.args > 0 {
+				want, _ := strconv.Atoi(m[2])
+				got, _ := strconv.Atoi(m[3])
+				tail = fmt.Sprintf(" want %v%v got %v", m[1], want-r.args, got-r.args) + tail[len(m[0]):]
+			}
+			rest = prefix + r.call + ":" + tail
+		}
+		msg = msg[:i] + "at <" + r.text + ">: " + rest
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
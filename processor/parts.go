@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"fmt"
+	"text/template"
+	"text/template/parse"
+)
+
+// part is a run of consecutive input files that use the same delimiters. Each part is parsed as a template of its
+// own; the top-level nodes of later parts are appended to the first one, so that all parts run as one template.
+type part struct {
+	tmpl     string  // Name of the template
+	src      *source // Template text, preceded by declarations of the variables of earlier parts
+	included bool    // When true, the template is a file that is included or imported, named after its path
+}
+
+// parts are the parts of a parsed source.
+type parts []*part
+
+// fix rewrites locations in the parts that an error states, to locations in the input files. See source.fix.
+func (ps parts) fix(err error) error {
+	// Included files go first: their locations must still follow "template: ", which fixing other parts removes.
+	for _, pt := range ps {
+		if pt.included {
+			err = pt.src.fixIn(includedLocation(pt.tmpl), err)
+		}
+	}
+	for _, pt := range ps {
+		if !pt.included {
+			err = pt.src.fixIn(locationIn(pt.tmpl), err)
+		}
+	}
+	return err
+}
+
+// parse parses a source into the template set of tpl, as the template with the given name. Runs of files with the same
+// delimiters are parsed as separate parts, which share the definitions and functions of the set. Variables that are
+// declared at the top level of a part are available in later parts; vars lists variables that are declared before the
+// source (e.g. by a prelude). Raw blocks are expanded before parsing, see expandRaw. Files that the source includes
+// are loaded, stack is the bottom of the include stack. Calls of some builtins are rewritten, see rewriteCalls. The
+// parts are returned for error messages, also when parsing fails.
+func (p *Processor) parse(tpl *template.Template, name string, src *source, vars, stack []string) (*template.Template,
+	parts, error) {
+	if p.o.Strict {
+		tpl.Option("missingkey=error")
+	}
+	inc := &includer{
+		p:   p,
+		tpl: tpl,
+	}
+	var main *template.Template
+	var ps parts
+	for _, run := range p.runs(src) {
+		// Parsing fails when a part uses variables of earlier parts, so it is preceded by declarations of these.
+		var first segment
+		if len(run.segments) > 0 {
+			first = run.segments[0]
+		}
+		left, right := p.delims(first)
+		decls := ""
+		for _, v := range vars {
+			decls += left + v + " := 0" + right
+		}
+		pt := &part{
+			tmpl: name,
+			src:  run.prefixed(decls),
+		}
+		if main != nil {
+			pt.tmpl = fmt.Sprintf("%v-%v", name, len(ps)+1)
+		}
+		ps = append(ps, pt)
+		text, offset, err := expandRaw(pt.src.text, left, right)
+		if err != nil {
+			return nil, ps, fmt.Errorf("%v: %v", pt.src.location(offset), err)
+		}
+		t, err := tpl.New(pt.tmpl).Delims(left, right).Parse(text)
+		if err != nil {
+			return nil, ps, err
+		}
+		if err := p.permitted(tpl); err != nil {
+			return nil, ps, err
+		}
+		err = inc.load(t, pt.src.fileAt, stack)
+		ps = append(ps, inc.loaded...)
+		inc.loaded = nil
+		if err != nil {
+			return nil, ps, err
+		}
+		nodes := t.Tree.Root.Nodes[len(vars):]
+		if main == nil {
+			main = t
+			main.Tree.Root.Nodes = nodes
+		} else {
+			main.Tree.Root.Nodes = append(main.Tree.Root.Nodes, nodes...)
+		}
+		vars = appendVars(vars, main.Tree)
+	}
+	p.rewriteCalls(tpl, ps)
+	if p.o.Debug {
+		p.addBreakpoints(tpl, ps)
+	}
+	p.rewritten.commit()
+	return main, ps, nil
+}
+
+// runs splits a source into runs of consecutive files with the same delimiters. A source without files is one run.
+func (p *Processor) runs(src *source) []*source {
+	if len(src.segments) == 0 {
+		return []*source{src}
+	}
+	var runs []*source
+	start := 0
+	for i := 1; i <= len(src.segments); i++ {
+		if i < len(src.segments) {
+			l1, r1 := p.delims(src.segments[start])
+			l2, r2 := p.delims(src.segments[i])
+			if l1 == l2 && r1 == r2 {
+				continue
+			}
+		}
+		runs = append(runs, src.slice(start, i))
+		start = i
+	}
+	return runs
+}
+
+// delims returns the delimiters of a file: those of its front matter, or those of the processor.
+func (p *Processor) delims(seg segment) (string, string) {
+	left, right := p.leftDelim, p.rightDelim
+	if seg.fm != nil && seg.fm.LeftDelimiter != "" {
+		left, right = seg.fm.LeftDelimiter, seg.fm.RightDelimiter
+	}
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	return left, right
+}
+
+// appendVars appends the variables that are declared at the top level of a parse tree to a list, when not yet present.
+func appendVars(vars []string, tree *parse.Tree) []string {
+	seen := map[string]bool{}
+	for _, v := range vars {
+		seen[v] = true
+	}
+	for _, n := range tree.Root.Nodes {
+		a, ok := n.(*parse.ActionNode)
+		if !ok || a.Pipe.IsAssign {
+			continue
+		}
+		for _, v := range a.Pipe.Decl {
+			if !seen[v.Ident[0]] {
+				seen[v.Ident[0]] = true
+				vars = append(vars, v.Ident[0])
+			}
+		}
+	}
+	return vars
+}
@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+const (
+	mainTemplate    = "gtpl"         // Name of the template that holds the concatenated input files
+	preludeTemplate = "gtpl-prelude" // Name of the template that holds the prelude files, see ProcessEach
+	stdinName       = "-"            // Name of the input file when reading stdin
+)
+
+// blobLocation matches a location in the concatenated template text, as text/template reports it:
+// "template: gtpl:LINE: ..." for parse errors and "template: gtpl:LINE:COL: ..." for execution errors.
+var blobLocation = locationIn(mainTemplate)
+
+// locationIn returns a regexp that matches a location in the template with the given name.
+func locationIn(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(template: )?\b` + regexp.QuoteMeta(name) + `:(\d+)(:(\d+))?`)
+}
+
+// includedLocation returns a regexp that matches a location in an included file, whose template is named after its
+// path. The location starts the text, or follows "template: ", so that paths ending in the same name don't match.
+func includedLocation(path string) *regexp.Regexp {
+	return regexp.MustCompile(`(template: |^)` + regexp.QuoteMeta(path) + `:(\d+)(:(\d+))?`)
+}
+
+// otherLocation matches the prefix of errors in other templates (e.g. included files), which already state their file.
+var otherLocation = regexp.MustCompile(`template: ([^:\s]+:\d+)`)
+
+// segment is an input file in the concatenated template text.
+type segment struct {
+	name   string       // File name, "-" for stdin
+	offset int          // Start of the file in the template text
+	lines  int          // Lines of the file that precede its template text, such as front matter
+	fm     *frontMatter // Front matter of the file, nil when absent
+}
+
+// source is the concatenated template text and the files that it consists of.
+type source struct {
+	text     string
+	segments []segment
+}
+
+// add appends a file to the source. Front matter or a delimiter directive is taken off the start of the file.
+func (src *source) add(name string, b []byte) error {
+	fm, text, lines, err := splitFrontMatter(name, b)
+	if err != nil {
+		return err
+	}
+	if fm == nil {
+		fm, text, lines = splitDirective(b)
+	}
+	src.segments = append(src.segments, segment{
+		name:   name,
+		offset: len(src.text),
+		lines:  lines,
+		fm:     fm,
+	})
+	src.text += string(text)
+	return nil
+}
+
+// with returns a new source, which is this one followed by another file.
+func (src *source) with(name string, b []byte) (*source, error) {
+	out := &source{
+		text:     src.text,
+		segments: append([]segment(nil), src.segments...),
+	}
+	if err := out.add(name, b); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// slice returns the files from up to (but not including) to as a source of their own.
+func (src *source) slice(from, to int) *source {
+	start, end := src.segments[from].offset, len(src.text)
+	if to < len(src.segments) {
+		end = src.segments[to].offset
+	}
+	out := &source{
+		text: src.text[start:end],
+	}
+	for _, seg := range src.segments[from:to] {
+		seg.offset -= start
+		out.segments = append(out.segments, seg)
+	}
+	return out
+}
+
+// prefixed returns the source, preceded by text without newlines. The first file then starts both at the start of the
+// text (for parse errors, which only state a line) and after the prefix (for errors that state a column).
+func (src *source) prefixed(prefix string) *source {
+	if prefix == "" || len(src.segments) == 0 {
+		return &source{
+			text:     prefix + src.text,
+			segments: src.segments,
+		}
+	}
+	out := &source{
+		text:     prefix + src.text,
+		segments: []segment{src.segments[0]},
+	}
+	for _, seg := range src.segments {
+		seg.offset += len(prefix)
+		out.segments = append(out.segments, seg)
+	}
+	return out
+}
+
+// stack returns the absolute paths of the input files, which are the bottom of the stack of included files.
+func (src *source) stack() []string {
+	stack := []string{}
+	for _, seg := range src.segments {
+		if abs, err := filepath.Abs(seg.name); err == nil && seg.name != stdinName {
+			stack = append(stack, abs)
+		}
+	}
+	return stack
+}
+
+// fileAt returns the name of the segment that holds a position in the template text.
+func (src *source) fileAt(pos parse.Pos) string {
+	return src.segmentAt(int(pos)).name
+}
+
+// segmentAt returns the segment that holds an offset in the template text.
+func (src *source) segmentAt(offset int) segment {
+	seg := src.segments[0]
+	for _, s := range src.segments {
+		if s.offset <= offset {
+			seg = s
+		}
+	}
+	return seg
+}
+
+// location returns "file:line:col" for an offset in the template text. Just as in text/template, columns are
+// byte offsets counting from 0.
+func (src *source) location(offset int) string {
+	seg := src.segmentAt(offset)
+	before := src.text[seg.offset:offset]
+	line := 1 + seg.lines + strings.Count(before, "\n")
+	col := len(before) - (strings.LastIndex(before, "\n") + 1)
+	return fmt.Sprintf("%v:%v:%v", displayName(seg.name), line, col)
+}
+
+// offsetOf converts a line (counting from 1) and a column (counting from 0) in the template text to an offset.
+func (src *source) offsetOf(line, col int) int {
+	offset := 0
+	for l := 1; l < line; l++ {
+		nl := strings.IndexByte(src.text[offset:], '\n')
+		if nl < 0 {
+			break
+		}
+		offset += nl + 1
+	}
+	offset += col
+	if offset > len(src.text) {
+		offset = len(src.text)
+	}
+	return offset
+}
+
+// fix rewrites locations in the concatenated template text that an error states, to locations in the input files.
+// Parse errors only state a line, which is mapped to the file and line where that line starts.
+func (src *source) fix(err error) error {
+	return src.fixIn(blobLocation, err)
+}
+
+// fixIn is the workhorse of fix, it rewrites the locations that a regexp such as blobLocation matches.
+func (src *source) fixIn(re *regexp.Regexp, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := re.ReplaceAllStringFunc(err.Error(), func(loc string) string {
+		m := re.FindStringSubmatch(loc)
+		line, _ := strconv.Atoi(m[2])
+		if m[4] == "" {
+			seg := src.segmentAt(src.offsetOf(line, 0))
+			l := strings.Count(src.text[seg.offset:src.offsetOf(line, 0)], "\n") + 1 + seg.lines
+			return fmt.Sprintf("%v:%v", displayName(seg.name), l)
+		}
+		col, _ := strconv.Atoi(m[4])
+		return src.location(src.offsetOf(line, col))
+	})
+	msg = otherLocation.ReplaceAllString(msg, "$1")
+	if msg == err.Error() {
+		// Keep errors such as context.Canceled as they are, for errors.Is.
+		return err
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// displayName is the name of an input file in error messages.
+func displayName(name string) string {
+	if name == stdinName {
+		return "stdin"
+	}
+	return name
+}
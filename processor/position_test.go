@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFix(t *testing.T) {
+	src := &source{
+		text: "a\nb\n" + "c\n  d" + "ef\n",
+		segments: []segment{
+			{name: "one.tpl", offset: 0},
+			{name: "two.tpl", offset: 4},
+			{name: "three.tpl", offset: 9},
+		},
+	}
+	for _, test := range []struct {
+		err  string
+		want string
+	}{
+		{
+			err:  "template: gtpl:1: unexpected EOF",
+			want: "one.tpl:1: unexpected EOF",
+		},
+		{
+			err:  "template: gtpl:4:2: executing \"gtpl\" at <die>: error calling die: stop",
+			want: "two.tpl:2:2: executing \"gtpl\" at <die>: error calling die: stop",
+		},
+		{
+			// two.tpl doesn't end in a newline, three.tpl starts halfway line 4, which is "  def".
+			err:  "template: gtpl:4:3: boom",
+			want: "three.tpl:1:0: boom",
+		},
+		{
+			err:  "template: gtpl:1:0: error calling include: template: lib/x.tpl:3:4: boom",
+			want: "one.tpl:1:0: error calling include: lib/x.tpl:3:4: boom",
+		},
+		{
+			err:  "no location here",
+			want: "no location here",
+		},
+	} {
+		if got := src.fix(errors.New(test.err)).Error(); got != test.want {
+			t.Errorf("fix(%q) = %q, want %q", test.err, got, test.want)
+		}
+	}
+	if src.fix(nil) != nil {
+		t.Error("fix(nil) != nil")
+	}
+}
+
+func TestProcessFilesErrorLocation(t *testing.T) {
+	dir := t.TempDir()
+	common := writeFile(t, dir, "common.tpl", "{{ $answer := 42 }}\n\n")
+	onecase := writeFile(t, dir, "case.tpl", "Answer:\n  {{ assert (eq $answer 41) \"wrong answer\" }}\n")
+	p := New(&Opts{AllowAliases: true})
+	err := p.ProcessFiles([]string{common, onecase}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("ProcessFiles(...) = nil, want error")
+	}
+	if want := onecase + ":2:5: "; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("ProcessFiles(...) = %q, want prefix %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePprof writes the profile in the format of pprof (https://github.com/google/pprof), a gzipped protocol buffer.
+// Each call is a function, named after its template (e.g. `template "host"`) or builtin, at the position of the call.
+// Samples hold the number of calls and their self time.
+func (pr *Profile) WritePprof(w io.Writer) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	strs := &stringTable{index: map[string]uint64{}}
+	strs.of("")
+	var out protoBuffer
+	for _, vt := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}} {
+		var b protoBuffer
+		b.uint(1, strs.of(vt[0]))
+		b.uint(2, strs.of(vt[1]))
+		out.message(1, &b)
+	}
+
+	// Samples are sorted by stack, so that the output is the same for the same profile.
+	var keys []string
+	for k := range pr.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	functions := map[string]uint64{}
+	locations := map[string]uint64{}
+	var funcs, locs protoBuffer
+	for _, k := range keys {
+		s := pr.samples[k]
+		var ids []uint64
+		for i := len(s.stack) - 1; i >= 0; i-- {
+			f := s.stack[i]
+			name, file, line := pprofFrame(f)
+			fid, ok := functions[name+"\n"+file]
+			if !ok {
+				fid = uint64(len(functions) + 1)
+				functions[name+"\n"+file] = fid
+				var b protoBuffer
+				b.uint(1, fid)
+				b.uint(2, strs.of(name))
+				b.uint(3, strs.of(name))
+				b.uint(4, strs.of(file))
+				funcs.message(5, &b)
+			}
+			lk := fmt.Sprintf("%v\n%v", fid, line)
+			lid, ok := locations[lk]
+			if !ok {
+				lid = uint64(len(locations) + 1)
+				locations[lk] = lid
+				var l protoBuffer
+				l.uint(1, fid)
+				l.uint(2, line)
+				var b protoBuffer
+				b.uint(1, lid)
+				b.message(4, &l)
+				locs.message(4, &b)
+			}
+			ids = append(ids, lid)
+		}
+		var b protoBuffer
+		b.packed(1, ids)
+		b.packed(2, []uint64{uint64(s.calls), uint64(s.self)})
+		out.message(2, &b)
+	}
+	out.Write(locs.Bytes())
+	out.Write(funcs.Bytes())
+	for _, s := range strs.strings {
+		out.bytes(6, []byte(s))
+	}
+	out.uint(10, uint64(pr.total))
+	var period protoBuffer
+	period.uint(1, strs.of("time"))
+	period.uint(2, strs.of("nanoseconds"))
+	out.message(11, &period)
+	out.uint(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pprofFrame returns the function name, the file and the line of a call for pprof.
+func pprofFrame(f *frame) (string, string, uint64) {
+	name := f.name
+	if f.template {
+		name = fmt.Sprintf("template %q", f.name)
+	}
+	file, line := lineOf(f.pos), ""
+	if i := strings.LastIndex(file, ":"); i > 0 {
+		file, line = file[:i], file[i+1:]
+	}
+	n, _ := strconv.ParseUint(line, 10, 64)
+	return name, file, n
+}
+
+// stringTable is the table of strings of a pprof profile, which other fields refer to by index.
+type stringTable struct {
+	strings []string
+	index   map[string]uint64
+}
+
+// of returns the index of a string, which is added when needed.
+func (st *stringTable) of(s string) uint64 {
+	i, ok := st.index[s]
+	if !ok {
+		i = uint64(len(st.strings))
+		st.index[s] = i
+		st.strings = append(st.strings, s)
+	}
+	return i
+}
+
+// protoBuffer encodes the fields of a protocol buffer message, see https://protobuf.dev/programming-guides/encoding.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+// varint writes an unsigned integer in 7-bit groups, the lowest first.
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+// uint writes an integer field.
+func (b *protoBuffer) uint(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// bytes writes a field of bytes, such as a string.
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+// message writes a field that is a message.
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.Bytes())
+}
+
+// packed writes a repeated integer field.
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.Bytes())
+}
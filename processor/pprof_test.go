@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// protoFields decodes the fields of a protocol buffer message, by number. Integers are returned as uint64, other
+// fields as []byte.
+func protoFields(t *testing.T, b []byte) map[int][]interface{} {
+	t.Helper()
+	varint := func() uint64 {
+		var x uint64
+		for shift := 0; ; shift += 7 {
+			if len(b) == 0 {
+				t.Fatalf("protocol buffer ends within a varint")
+			}
+			c := b[0]
+			b = b[1:]
+			x |= uint64(c&0x7f) << shift
+			if c < 0x80 {
+				return x
+			}
+		}
+	}
+	fields := map[int][]interface{}{}
+	for len(b) > 0 {
+		key := varint()
+		switch key & 7 {
+		case 0:
+			fields[int(key>>3)] = append(fields[int(key>>3)], varint())
+		case 2:
+			n := varint()
+			fields[int(key>>3)] = append(fields[int(key>>3)], b[:n])
+			b = b[n:]
+		default:
+			t.Fatalf("protocol buffer has unexpected wire type %v", key&7)
+		}
+	}
+	return fields
+}
+
+func TestWritePprof(t *testing.T) {
+	p := New(&Opts{AllowAliases: true, Profile: true})
+	tpl := `{{ define "x" }}{{ add 1 2 }}{{ end }}{{ template "x" }}{{ template "x" }}`
+	if err := p.ProcessStreams(strings.NewReader(tpl), io.Discard); err != nil {
+		t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+	}
+	var buf bytes.Buffer
+	if err := p.Profile().WritePprof(&buf); err != nil {
+		t.Fatalf("WritePprof(...) = %v, need nil error", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("WritePprof(...) wrote no gzip data: %v", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("WritePprof(...) wrote bad gzip data: %v", err)
+	}
+
+	fields := protoFields(t, b)
+	var strs []string
+	for _, s := range fields[6] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	want := []string{"", "calls", "count", "time", "nanoseconds", "(top level)", `template "x"`, "stdin", "add"}
+	if !reflect.DeepEqual(strs, want) {
+		t.Errorf("WritePprof(...) wrote strings %q, want %q", strs, want)
+	}
+
+	// Samples are by stack: the top level, and the template and the builtin for both calls of the template. Each has
+	// the number of calls as first (packed) value, which fits in one byte.
+	var calls []uint64
+	for _, s := range fields[2] {
+		values := protoFields(t, s.([]byte))[2][0].([]byte)
+		calls = append(calls, uint64(values[0]))
+	}
+	if want := []uint64{1, 1, 1, 1, 1}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("WritePprof(...) wrote samples with %v calls, want %v", calls, want)
+	}
+	if got := len(fields[4]); got != 3 {
+		t.Errorf("WritePprof(...) wrote %v locations, want 3", got)
+	}
+	if got := len(fields[5]); got != 3 {
+		t.Errorf("WritePprof(...) wrote %v functions, want 3", got)
+	}
+}
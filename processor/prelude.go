@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"text/template"
+)
+
+// Prelude is a set of files that precede other templates, such as common settings. It is parsed once, and can then
+// precede templates of several processors, also concurrently. See ParsePrelude.
+type Prelude struct {
+	files []string
+	base  *template.Template // Parsed prelude, the templates are parsed into clones of it
+	src   *source
+	ps    parts // Parts of the prelude, for error messages
+}
+
+// ParsePrelude reads and parses prelude files, and loads what they include, using the delimiters and include path of
+// the options.
+func (p *Processor) ParsePrelude(files []string) (*Prelude, error) {
+	src, err := readFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	base, ps, err := p.parse(template.New(preludeTemplate).Funcs(p.fmap), preludeTemplate, src, nil, src.stack())
+	if err != nil {
+		return nil, ps.fix(err)
+	}
+	return &Prelude{
+		files: files,
+		base:  base,
+		src:   src,
+		ps:    ps,
+	}, nil
+}
+
+// ProcessToFileWithPrelude is ProcessToFile for templates that are preceded by a prelude, as if the prelude files were
+// the first ones. Only the templates are parsed, into a clone of the prelude, using the delimiters and include path
+// of this processor. The output file must be given.
+func (p *Processor) ProcessToFileWithPrelude(pre *Prelude, files []string, path string) error {
+	return p.ProcessToFileWithPreludeContext(context.Background(), pre, files, path)
+}
+
+// ProcessToFileWithPreludeContext is ProcessToFileWithPrelude that stops when a context is done. Nothing is written
+// then.
+func (p *Processor) ProcessToFileWithPreludeContext(ctx context.Context, pre *Prelude, files []string,
+	path string) error {
+	p.setInputs(append(append([]string(nil), pre.files...), files...))
+	if path == "" {
+		return errors.New("no output file")
+	}
+	data, err := loadData(p.o.DataFiles)
+	if err != nil {
+		return err
+	}
+	c, err := p.compile(pre.base, pre.src, pre.ps, files)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	r, err := p.executeCompiled(ctx, c, data, nil, &buf)
+	if err != nil {
+		return err
+	}
+	return p.writeOutput(path, buf.Bytes(), r)
+}
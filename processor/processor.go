@@ -2,106 +2,339 @@
 package processor
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
+	"time"
 
+	"github.com/KarelKubat/gtpl/diff"
 	"github.com/KarelKubat/gtpl/syringe"
 )
 
 const (
 	gtplNamePrefix = ".Gtpl" // Prefix for full function names, must match the membername in the `injected` struct
+	diffContext    = 3       // Lines of context in diffs
 )
 
 // Opts control how the processor works.
 type Opts struct {
-	AllowAliases     bool           // When true, allow short function names ("map") as aliases (for "".Gtpl.Map")
-	LeftDelimiter    string         // When "", defaults to "{{"
-	RightDelimter    string         // When "", defaults to "}}"
-	RemoveEmptyLines bool           // When true, remove empty lines from the output
-	ListTemplate     bool           // When true, list template with line numbers before processing
-	Logger           syringe.Logger // When nil, defaults to https://pkg.go.dev/log
+	AllowAliases     bool                 // When true, allow short function names ("map") as aliases (for "".Gtpl.Map")
+	LeftDelimiter    string               // When "", defaults to "{{"
+	RightDelimter    string               // When "", defaults to "}}"
+	RemoveEmptyLines bool                 // When true, remove empty lines from the output
+	ListTemplate     bool                 // When true, list template with line numbers before processing
+	Logger           syringe.Logger       // When nil, defaults to https://pkg.go.dev/log
+	DataFiles        []string             // JSON or YAML files ("FILE" or "NAME=FILE") that are exposed as `.Data`
+	Values           []string             // Overrides "key.path=value" that are applied to `.Data` after loading DataFiles
+	IncludePath      []string             // Directories to search for included files, before $GTPL_PATH
+	OutputDir        string               // Directory for files written using "file", when "" the current directory
+	ManagedBlock     string               // When set, output files only get the block between marker lines with this name
+	ManagedComment   string               // Start of marker lines of managed blocks, when "" defaults to "#"
+	KeepGoing        bool                 // When true, "assert" and "die" don't stop but fail at the end, just like "check"
+	Strict           bool                 // When true, missing map keys (also for "getval") and unset variables ("env") fail
+	Sandbox          bool                 // When true, builtins with side effects and front matter "output" are denied
+	Permit           []syringe.Capability // Capabilities that are allowed in a sandbox, e.g. syringe.CapEnv
+	Limits           Limits               // Bounds on the resources that execution takes
+	Debug            bool                 // When true, "breakpoint" pauses execution and opens an inspector
+	DebugInput       io.Reader            // Commands for the inspector, when nil os.Stdin
+	DebugOutput      io.Writer            // Output of the inspector, when nil os.Stderr
+	Trace            bool                 // When true, log calls of builtins and templates with their arguments and results
+	Profile          bool                 // When true, measure the time of calls of builtins and templates, see Profile
+	ProfileTo        *Profile             // When set, Profile adds to this profile (e.g. of another processor)
+}
+
+// Limits bound the resources that the execution of a template takes. When a limit is exceeded, execution fails. Zero
+// values mean that there is no limit.
+type Limits struct {
+	Timeout   time.Duration // Wall-clock time of executing one template
+	MaxOutput int           // Bytes of output, including the output for files written using "file"
+	MaxLoop   int           // Iterations of one "loop"
 }
 
 // Processor is the receiver.
 type Processor struct {
-	o          *Opts            // Input options
-	needle     *syringe.Syringe // Actual template processor
-	fmap       template.FuncMap // Non-empty when aliases are allowed
-	leftDelim  string           // start-of-instruction
-	rightDelim string           // end-of-instruction
+	o          *Opts             // Input options
+	needle     *syringe.Syringe  // Actual template processor
+	fmap       template.FuncMap  // Functions that templates call by name, see funcs
+	names      map[string]string // Builtin names by alias
+	leftDelim  string            // start-of-instruction
+	rightDelim string            // end-of-instruction
+	inputs     []string          // Files read during the last run
+	debugIn    *bufio.Scanner    // Commands for the inspector, see debugInput
+	profile    *Profile          // Time of calls, when profiling
+	rewritten  *rewrites         // Rewritten calls, for error messages
 }
 
 func New(o *Opts) *Processor {
 	p := &Processor{
-		o: o,
-		needle: syringe.New(&syringe.Opts{
-			Logger: o.Logger,
-		}),
+		o:          o,
+		needle:     syringe.New(o.syringeOpts()),
 		fmap:       template.FuncMap{},
+		names:      map[string]string{},
 		leftDelim:  o.LeftDelimiter,
 		rightDelim: o.RightDelimter,
+		rewritten:  newRewrites(),
 	}
 
-	// Patch up non-standard options
-	if o.AllowAliases {
-		p.fmap = p.needle.AliasesMap()
+	for _, b := range p.needle.Builtins() {
+		p.names[b.Alias] = b.Name
+	}
+	p.fmap = p.funcs(p.needle)
+	if o.Profile {
+		p.profile = o.ProfileTo
+		if p.profile == nil {
+			p.profile = newProfile()
+		}
 	}
 	return p
 }
 
+// funcs returns the builtins of a Syringe that templates call by name: all aliases when these are allowed. The
+// variants that calls are rewritten to are added when executing, see exec.
+func (p *Processor) funcs(needle *syringe.Syringe) template.FuncMap {
+	if !p.o.AllowAliases {
+		return template.FuncMap{}
+	}
+	return needle.AliasesMap()
+}
+
+// tracing returns whether calls of builtins and templates are rewritten to calls that trace them, for Trace or Profile.
+func (o *Opts) tracing() bool {
+	return o.Trace || o.Profile
+}
+
+// syringeOpts returns the options for the Syringes of a processor.
+func (o *Opts) syringeOpts() *syringe.Opts {
+	return &syringe.Opts{
+		Logger:    o.Logger,
+		KeepGoing: o.KeepGoing,
+		Sandbox:   o.Sandbox,
+		Permit:    o.Permit,
+		MaxLoop:   o.Limits.MaxLoop,
+		Trace:     o.Trace,
+	}
+}
+
 type injected struct {
 	Gtpl *syringe.Syringe
+	Data map[interface{}]interface{}
 }
 
 // ProcessStreams reads the template to process from an io.Reader and runs it. The output goes to an io.Writer.
 func (p *Processor) ProcessStreams(r io.Reader, w io.Writer) error {
+	return p.ProcessStreamsContext(context.Background(), r, w)
+}
+
+// ProcessStreamsContext is ProcessStreams that stops when a context is done. Opts.Limits.Timeout further limits the
+// time that execution takes.
+func (p *Processor) ProcessStreamsContext(ctx context.Context, r io.Reader, w io.Writer) error {
 	// Collect everything from the input reader.
 	var buf bytes.Buffer
 	_, err := buf.ReadFrom(r)
 	if err != nil {
 		return err
 	}
+	src := &source{}
+	if err := src.add(stdinName, buf.Bytes()); err != nil {
+		return err
+	}
+	return p.process(ctx, src, w)
+}
+
+// process runs the template text of a source. The output goes to an io.Writer, or to the output file that front matter
+// states (creating its directory as needed). Output for separate files is written when processing succeeds.
+func (p *Processor) process(ctx context.Context, src *source, w io.Writer) error {
+	path, err := p.output(src)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return p.processToFile(ctx, src, path)
+	}
+	if p.o.ManagedBlock != "" {
+		return errors.New("a managed block needs an output file")
+	}
+	r, err := p.execute(ctx, src, w)
+	if err != nil {
+		return err
+	}
+	return r.write()
+}
+
+// execute runs the template text of a source. The output goes to an io.Writer, output for separate files is returned
+// in the router. Errors that state locations in the template text are rewritten to state locations in the input files.
+func (p *Processor) execute(ctx context.Context, src *source, w io.Writer) (*router, error) {
+	r, ps, err := p.run(ctx, src, w)
+	return r, ps.fix(err)
+}
 
-	str := buf.String()
+// run is the workhorse of execute, it also returns the parts of the source for error messages.
+func (p *Processor) run(ctx context.Context, src *source, w io.Writer) (*router, parts, error) {
+	var files []string
+	for _, seg := range src.segments {
+		files = append(files, seg.name)
+	}
+	p.setInputs(files)
 
 	// If requested, show the collected template on stdout.
 	if p.o.ListTemplate {
-		for nr, line := range strings.Split(str, "\n") {
+		for nr, line := range strings.Split(src.text, "\n") {
 			fmt.Printf("%3d %v\n", nr+1, line)
 		}
 	}
 
-	// Run the template.
-	tpl, err := template.New("gtpl").Funcs(p.fmap).Delims(p.leftDelim, p.rightDelim).Parse(str)
+	// Load the data files, add the data of front matter and apply overrides.
+	data, err := loadData(p.o.DataFiles)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	src.mergeData(data)
+	if err := setValues(data, p.o.Values); err != nil {
+		return nil, nil, err
+	}
+
+	// Parse the template and load included files. Relative names are resolved against the file holding the include.
+	// The input files are the bottom of the stack, so that including them again is a cycle.
+	tpl, ps, err := p.parse(template.New(mainTemplate).Funcs(p.fmap), mainTemplate, src, nil, src.stack())
+	if err != nil {
+		return nil, ps, err
+	}
+	r, err := p.exec(ctx, tpl, p.needle, data, src.removeEmptyLines(p.o.RemoveEmptyLines), w)
+	return r, ps, err
+}
+
+// exec executes a parsed template with the builtins of a Syringe and the data. The output goes to an io.Writer, output
+// for separate files is returned in the router. Execution stops when the context is done, or when it exceeds the
+// limits of the options. The context is cancelled when execution ends, which stops builtins that run in the
+// background, such as "loop" when its range is left early. Errors state rewritten calls as they were written.
+func (p *Processor) exec(ctx context.Context, tpl *template.Template, needle *syringe.Syringe,
+	data map[interface{}]interface{}, removeEmpty bool, w io.Writer) (*router, error) {
+	r, err := p.execVariants(ctx, tpl, needle, data, removeEmpty, w)
+	return r, p.rewritten.fix(err)
+}
+
+// execVariants is the workhorse of exec. It executes a clone of the template, to which the variants of builtins are
+// added: the template set itself keeps only the builtins that templates may call by name, e.g. for a REPL that parses
+// more. The builtins of the clone are those of the Syringe, they fail when execution must stop (see runningFuncs).
+func (p *Processor) execVariants(ctx context.Context, tpl *template.Template, needle *syringe.Syringe,
+	data map[interface{}]interface{}, removeEmpty bool, w io.Writer) (*router, error) {
+	tpl, err := tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if p.o.Limits.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, p.o.Limits.Timeout)
+		defer cancelTimeout()
+	}
+	exe := syringe.Execution{Context: ctx}
+	if p.profile != nil {
+		rec := p.profile.record(p)
+		exe.Profiler = rec
+		defer rec.finish()
+	}
+	if p.o.Debug {
+		exe.Debugger = &inspector{
+			p:     p,
+			tpl:   tpl,
+			abort: cancel,
+		}
 	}
 
+	// Execution gets the injected builtins and the data. Output goes through a router, for "file" blocks.
+	inj := &injected{
+		Gtpl: needle,
+		Data: data,
+	}
+	inc := &includer{
+		p:    p,
+		tpl:  tpl,
+		data: inj,
+	}
+	exe.Includer = inc
+	r := &router{
+		p:                p,
+		main:             w,
+		removeEmptyLines: removeEmpty,
+	}
+	exe.Router = r
+	syringe.Attach(needle, exe)
+	lw := &limitedWriter{
+		w:      r,
+		ctx:    ctx,
+		limits: &p.o.Limits,
+	}
+	inc.w = lw
+	fmap := p.funcs(needle)
+	for alias, f := range syringe.Variants(needle) {
+		fmap[alias] = f
+	}
+	tpl.Funcs(runningFuncs(lw, fmap))
+
 	// If we don't need to postprocess the output for empty lines, then the template can be executed and the output goes
 	// directly to the requrested writer.
-	if !p.o.RemoveEmptyLines {
-		return tpl.Execute(w, &injected{
-			Gtpl: p.needle,
-		})
+	if !removeEmpty {
+		if err := execute(tpl, needle, lw, inj); err != nil {
+			return nil, err
+		}
+		return r, r.finish()
 	}
 
-	// To remove empty lines, we need to collect the execution output and re-examine it.
+	// To remove empty lines, we need to collect the execution output and re-examine it. Nothing is written when
+	// execution fails.
 	var wrbuf bytes.Buffer
-	err = tpl.Execute(&wrbuf, &injected{
-		Gtpl: p.needle,
-	})
+	r.main = &wrbuf
+	if err := execute(tpl, needle, lw, inj); err != nil {
+		return nil, err
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+	_, err = w.Write(removeEmptyLines(wrbuf.Bytes()))
+	return r, err
+}
+
+// execute executes a template. Failures that the Syringe recorded (see "check") make execution fail, they are listed
+// one per line, before the error that stopped execution (if any). When the context of the writer is done, that is the
+// error: e.g. "loop" stops without an error, and other errors may be caused by stopping.
+func execute(tpl *template.Template, needle *syringe.Syringe, w *limitedWriter, data interface{}) error {
+	err := tpl.Execute(w, data)
+	if e := w.err(); e != nil {
+		syringe.Failures(needle)
+		return e
+	}
+	failures := syringe.Failures(needle)
+	if len(failures) == 0 {
+		return err
+	}
+	if err != nil {
+		failures = append(failures, err.Error())
+	}
+	return errors.New(strings.Join(failures, "\n"))
+}
+
+// removeEmptyLines returns output without lines that are empty or only hold whitespace.
+func removeEmptyLines(b []byte) []byte {
 	var trimmed bytes.Buffer
-	for _, line := range strings.Split(wrbuf.String(), "\n") {
+	for _, line := range strings.Split(string(b), "\n") {
 		if strings.TrimSpace(line) != "" {
 			trimmed.WriteString(line + "\n")
 		}
 	}
-	trimmed.WriteTo(w)
-	return err
+	return trimmed.Bytes()
 }
 
 // Builtins returns the "usage" information of the builtin functions, just as syringe.Overview does.
@@ -127,27 +360,168 @@ func (p *Processor) Overview() string {
 
 // ProcessFiles reads templates from files. The output goes to an io.Writer.
 func (p *Processor) ProcessFiles(files []string, w io.Writer) error {
-	var total bytes.Buffer
+	return p.ProcessFilesContext(context.Background(), files, w)
+}
+
+// ProcessFilesContext is ProcessFiles that stops when a context is done.
+func (p *Processor) ProcessFilesContext(ctx context.Context, files []string, w io.Writer) error {
+	p.setInputs(files)
+	src, err := readFiles(files)
+	if err != nil {
+		return err
+	}
+	return p.process(ctx, src, w)
+}
+
+// setInputs states the input files and data files as the inputs of a run (see Inputs), before anything is read. Files
+// that the run includes are added later. So when a run fails early, e.g. because an input file doesn't exist yet, its
+// inputs are still known.
+func (p *Processor) setInputs(files []string) {
+	p.inputs = nil
+	for _, f := range files {
+		if f != stdinName {
+			p.inputs = append(p.inputs, f)
+		}
+	}
+	for _, spec := range p.o.DataFiles {
+		p.inputs = append(p.inputs, dataFileName(spec))
+	}
+}
+
+// readFiles reads and concatenates files into a source.
+func readFiles(files []string) (*source, error) {
+	src := &source{}
 	for _, f := range files {
 		var b []byte
 		var err error
-		if f == "-" {
+		if f == stdinName {
 			var stdin bytes.Buffer
 			_, err = stdin.ReadFrom(os.Stdin)
-			if err != nil {
-				return err
-			}
 			b = stdin.Bytes()
 		} else {
 			b, err = os.ReadFile(f)
-			if err != nil {
-				return err
-			}
 		}
-		_, err = total.Write(b)
 		if err != nil {
+			return nil, err
+		}
+		if err := src.add(f, b); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// ProcessToFile reads templates from files, and writes the output to a file. The file is only written when processing
+// succeeds, and only when its content changes; otherwise it's left untouched. When Opts.ManagedBlock is set, only the
+// lines between "# BEGIN gtpl:NAME" and "# END gtpl:NAME" are replaced, the block is appended when these are absent.
+// When path is "", the output file is taken from the front matter of the files.
+func (p *Processor) ProcessToFile(files []string, path string) error {
+	return p.ProcessToFileContext(context.Background(), files, path)
+}
+
+// ProcessToFileContext is ProcessToFile that stops when a context is done. Nothing is written then.
+func (p *Processor) ProcessToFileContext(ctx context.Context, files []string, path string) error {
+	p.setInputs(files)
+	src, err := readFiles(files)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if path, err = p.output(src); err != nil {
 			return err
 		}
 	}
-	return p.ProcessStreams(&total, w)
+	if path == "" {
+		return errors.New("no output file, and no front matter that states one")
+	}
+	return p.processToFile(ctx, src, path)
+}
+
+// output returns the output file that the front matter of a source states, or "" when none does. In a sandbox this
+// fails unless writing files is permitted.
+func (p *Processor) output(src *source) (string, error) {
+	path := src.output()
+	if path != "" && !p.needle.Permits(syringe.CapWrite) {
+		return "", fmt.Errorf("front matter output %v is not permitted in the sandbox, it needs capability %q", path,
+			syringe.CapWrite)
+	}
+	return path, nil
+}
+
+// processToFile is the workhorse of ProcessToFile.
+func (p *Processor) processToFile(ctx context.Context, src *source, path string) error {
+	var buf bytes.Buffer
+	r, err := p.execute(ctx, src, &buf)
+	if err != nil {
+		return err
+	}
+	return p.writeOutput(path, buf.Bytes(), r)
+}
+
+// writeOutput writes the output of processing to a file, or only its managed block, and then the files that the
+// router collected.
+func (p *Processor) writeOutput(path string, out []byte, r *router) error {
+	content, err := p.mainContent(path, out)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(path, content); err != nil {
+		return err
+	}
+	return r.write()
+}
+
+// Compare reads templates from files, and compares the output to the content of a file without writing it. Output
+// for separate files (see "file") is compared to these files. Compare returns unified diffs from the files to the
+// output, which are "" when all files are up to date. Missing files are compared as if they were empty. When path is
+// "", the output file is taken from the front matter of the files.
+func (p *Processor) Compare(files []string, path string) (string, error) {
+	return p.CompareContext(context.Background(), files, path)
+}
+
+// CompareContext is Compare that stops when a context is done.
+func (p *Processor) CompareContext(ctx context.Context, files []string, path string) (string, error) {
+	p.setInputs(files)
+	src, err := readFiles(files)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		if path, err = p.output(src); err != nil {
+			return "", err
+		}
+	}
+	if path == "" {
+		return "", errors.New("no output file to compare to, and no front matter that states one")
+	}
+	var buf bytes.Buffer
+	r, err := p.execute(ctx, src, &buf)
+	if err != nil {
+		return "", err
+	}
+	content, err := p.mainContent(path, buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	out, err := compareFile(path, content)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range r.files {
+		d, err := compareFile(f.path, f.content)
+		if err != nil {
+			return "", err
+		}
+		out += d
+	}
+	return out, nil
+}
+
+// compareFile returns a unified diff from a file to new content, or "" when they are the same.
+func compareFile(path string, content []byte) (string, error) {
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return diff.Unified(path, path+" (generated)", string(old), string(content), diffContext), nil
 }
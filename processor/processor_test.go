@@ -2,8 +2,14 @@ package processor
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestOverview(t *testing.T) {
@@ -37,3 +43,132 @@ func TestProcessStreams(t *testing.T) {
 		t.Errorf("ProcessStreams(...): output is %q, doesn't contain %q", wr.String(), wantString)
 	}
 }
+
+func TestLimits(t *testing.T) {
+	endless := `{{ range loop 0 1000000000 }}{{ end }}`
+	for _, test := range []struct {
+		tpl         string
+		limits      Limits
+		removeEmpty bool
+		wantError   string // "" when processing succeeds
+	}{
+		{
+			tpl:    `{{ range loop 0 3 }}{{ . }}{{ end }}`,
+			limits: Limits{Timeout: time.Minute, MaxOutput: 3, MaxLoop: 3},
+		},
+		{
+			tpl:       endless,
+			limits:    Limits{Timeout: 50 * time.Millisecond},
+			wantError: "execution exceeds the timeout of 50ms",
+		},
+		{
+			// Templates that write nothing stop too.
+			tpl: `{{ define "r" }}{{ if . }}{{ template "r" (slice . 1) }}{{ template "r" (slice . 1) }}{{ end }}` +
+				`{{ end }}{{ template "r" "0123456789012345678901234567890123456789" }}`,
+			limits:    Limits{Timeout: 200 * time.Millisecond},
+			wantError: "execution exceeds the timeout of 200ms",
+		},
+		{
+			tpl:       endless,
+			limits:    Limits{MaxLoop: 1000},
+			wantError: `stdin:1:9: executing "gtpl" at <loop 0 1000000000>: error calling loop: loop: 1000000000 iterations exceed the limit of 1000`,
+		},
+		{
+			// Loop is limited however templates reach it.
+			tpl:       `{{ with .Gtpl }}{{ range .Loop 0 50 }}{{ end }}{{ end }}`,
+			limits:    Limits{MaxLoop: 10},
+			wantError: `loop: 50 iterations exceed the limit of 10`,
+		},
+		{
+			tpl:       `{{ range loop 0 3 }}{{ . }}{{ end }}`,
+			limits:    Limits{MaxOutput: 2},
+			wantError: "output exceeds the limit of 2 bytes",
+		},
+		{
+			// Output for separate files counts too.
+			tpl:       `{{ file "a" }}123{{ endfile }}`,
+			limits:    Limits{MaxOutput: 2},
+			wantError: "output exceeds the limit of 2 bytes",
+		},
+		{
+			tpl:         "a\n\n\nb\n",
+			limits:      Limits{MaxOutput: 2},
+			removeEmpty: true,
+			wantError:   "output exceeds the limit of 2 bytes",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true, Limits: test.limits, RemoveEmptyLines: test.removeEmpty})
+		err := p.ProcessStreams(strings.NewReader(test.tpl), &bytes.Buffer{})
+		switch {
+		case err != nil && err.Error() != test.wantError:
+			t.Errorf("ProcessStreams(%q) with %+v = %v, want error %q", test.tpl, test.limits, err, test.wantError)
+		case err == nil && test.wantError != "":
+			t.Errorf("ProcessStreams(%q) with %+v = nil, want error %q", test.tpl, test.limits, test.wantError)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	dir := t.TempDir()
+	tpl := writeFile(t, dir, "a.tpl", `{{ range loop 0 1000000000 }}{{ end }}`)
+	out := filepath.Join(dir, "a.out")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := New(&Opts{AllowAliases: true})
+	for _, test := range []struct {
+		name string
+		run  func() error
+	}{
+		{
+			name: "ProcessStreamsContext",
+			run: func() error {
+				return p.ProcessStreamsContext(ctx, strings.NewReader(`{{ range loop 0 1000000000 }}{{ end }}`),
+					&bytes.Buffer{})
+			},
+		},
+		{
+			name: "ProcessFilesContext",
+			run:  func() error { return p.ProcessFilesContext(ctx, []string{tpl}, &bytes.Buffer{}) },
+		},
+		{
+			name: "ProcessToFileContext",
+			run:  func() error { return p.ProcessToFileContext(ctx, []string{tpl}, out) },
+		},
+		{
+			name: "CompareContext",
+			run: func() error {
+				_, err := p.CompareContext(ctx, []string{tpl}, out)
+				return err
+			},
+		},
+		{
+			name: "ProcessEachContext",
+			run:  func() error { return p.ProcessEachContext(ctx, nil, []string{tpl}, out) },
+		},
+	} {
+		if err := test.run(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v(cancelled, ...) = %v, want %v", test.name, err, context.Canceled)
+		}
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Errorf("%v exists after cancelled processing, want nothing written", out)
+	}
+}
+
+func TestAbandonedLoop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(&Opts{AllowAliases: true})
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if err := p.ProcessStreams(strings.NewReader(`{{ range loop 0 1000000000 }}{{ break }}{{ end }}`), &buf); err != nil {
+			t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+		}
+	}
+	// The goroutines of the loops stop once their contexts are cancelled, which may take a moment.
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("after abandoning loops: %v goroutines, want at most %v", after, before)
+	}
+}
@@ -0,0 +1,233 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const topLevel = "(top level)" // Name of the frame of an execution, outside of calls
+
+// Profile is the time that executions of templates take, by named template, builtin and line of the input files.
+// Calls are counted when they end. The total time of calls includes the calls that they make, their self time doesn't.
+// Executions count as calls of "(top level)", whose self time is spent outside of calls. Executions may add to a profile
+// concurrently.
+type Profile struct {
+	mu        sync.Mutex
+	total     time.Duration             // Time of all executions
+	templates map[string]*profileEntry  // By name, executions count as calls of topLevel
+	builtins  map[string]*profileEntry  // By alias
+	lines     map[string]*profileEntry  // By "file:line" of the calls
+	samples   map[string]*profileSample // Self time by stack of calls, for pprof
+}
+
+// profileEntry is the time of the calls of a template, builtin or line.
+type profileEntry struct {
+	calls       int
+	total, self time.Duration
+}
+
+// profileSample is the self time of calls with the same stack.
+type profileSample struct {
+	stack []*frame // The execution first, the call last
+	calls int
+	self  time.Duration
+}
+
+// frame is a call that is being measured.
+type frame struct {
+	name     string        // Template, builtin or topLevel
+	template bool          // Whether the call is of a template
+	pos      string        // Position of the call, "" for the execution
+	start    time.Time     // Start of the call
+	children time.Duration // Total time of the calls that the call makes
+}
+
+// newProfile returns an empty profile.
+func newProfile() *Profile {
+	return &Profile{
+		templates: map[string]*profileEntry{},
+		builtins:  map[string]*profileEntry{},
+		lines:     map[string]*profileEntry{},
+		samples:   map[string]*profileSample{},
+	}
+}
+
+// Profile returns the time that executions took so far, nil unless Opts.Profile is set.
+func (p *Processor) Profile() *Profile {
+	return p.profile
+}
+
+// recorder satisfies syringe.Profiler for one execution, it adds the calls to a profile when they end.
+type recorder struct {
+	p       *Processor
+	profile *Profile
+	open    []*frame // Calls that haven't ended, the execution first
+}
+
+// record returns a recorder for an execution.
+func (pr *Profile) record(p *Processor) *recorder {
+	return &recorder{
+		p:       p,
+		profile: pr,
+		open: []*frame{{
+			name:  topLevel,
+			start: time.Now(),
+		}},
+	}
+}
+
+// BeginBuiltin begins a call of a builtin. Calls by full name (e.g. ".Gtpl.GetVal") are counted as calls by alias.
+func (r *recorder) BeginBuiltin(pos, call string) {
+	if name, ok := strings.CutPrefix(call, gtplNamePrefix+"."); ok {
+		for alias, other := range r.p.names {
+			if other == name {
+				call = alias
+			}
+		}
+	}
+	r.open = append(r.open, &frame{
+		name:  call,
+		pos:   pos,
+		start: time.Now(),
+	})
+}
+
+// BeginTemplate begins a call of a template.
+func (r *recorder) BeginTemplate(pos, name string) {
+	r.open = append(r.open, &frame{
+		name:     name,
+		template: true,
+		pos:      pos,
+		start:    time.Now(),
+	})
+}
+
+// End ends the call that began last.
+func (r *recorder) End() {
+	if len(r.open) == 0 {
+		return
+	}
+	f := r.open[len(r.open)-1]
+	d := time.Since(f.start)
+	if len(r.open) > 1 {
+		r.open[len(r.open)-2].children += d
+	}
+	r.profile.add(r.open, d)
+	r.open = r.open[:len(r.open)-1]
+}
+
+// finish ends the execution, and the calls that didn't end because execution stopped.
+func (r *recorder) finish() {
+	for len(r.open) > 0 {
+		r.End()
+	}
+}
+
+// add adds the last call of a stack, which took d. A call within a call of the same template, builtin or line (e.g.
+// recursion) doesn't add to its total time, which the outer call already holds.
+func (pr *Profile) add(stack []*frame, d time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	f := stack[len(stack)-1]
+	self := d - f.children
+	nested, nestedLine := false, false
+	for _, outer := range stack[:len(stack)-1] {
+		nested = nested || (outer.name == f.name && outer.template == f.template)
+		nestedLine = nestedLine || (outer.pos != "" && lineOf(outer.pos) == lineOf(f.pos))
+	}
+	switch {
+	case f.pos == "":
+		pr.total += d
+		addEntry(pr.templates, f.name, d, self, false)
+	case f.template:
+		addEntry(pr.templates, f.name, d, self, nested)
+	default:
+		addEntry(pr.builtins, f.name, d, self, nested)
+	}
+	if f.pos != "" {
+		addEntry(pr.lines, lineOf(f.pos), d, self, nestedLine)
+	}
+
+	var key []string
+	for _, f := range stack {
+		key = append(key, f.name+"@"+f.pos)
+	}
+	k := strings.Join(key, "\n")
+	s, ok := pr.samples[k]
+	if !ok {
+		s = &profileSample{
+			stack: append([]*frame(nil), stack...),
+		}
+		pr.samples[k] = s
+	}
+	s.calls++
+	s.self += self
+}
+
+// addEntry adds a call to the entry of a name. A nested call only adds its self time.
+func addEntry(entries map[string]*profileEntry, name string, total, self time.Duration, nested bool) {
+	e, ok := entries[name]
+	if !ok {
+		e = &profileEntry{}
+		entries[name] = e
+	}
+	e.calls++
+	if !nested {
+		e.total += total
+	}
+	e.self += self
+}
+
+// lineOf returns "file:line" for a position "file:line:col".
+func lineOf(pos string) string {
+	if i := strings.LastIndex(pos, ":"); i > 0 {
+		return pos[:i]
+	}
+	return pos
+}
+
+// Report writes the profile as tables of templates, builtins and lines, each sorted by total time (highest first).
+func (pr *Profile) Report(w io.Writer) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for i, table := range []struct {
+		title   string
+		entries map[string]*profileEntry
+	}{
+		{"template", pr.templates},
+		{"builtin", pr.builtins},
+		{"line", pr.lines},
+	} {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%v\tcalls\ttotal\tself\n", table.title)
+		var names []string
+		for name := range table.entries {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := table.entries[names[i]], table.entries[names[j]]
+			if a.total != b.total {
+				return a.total > b.total
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			e := table.entries[name]
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", name, e.calls, roundDuration(e.total), roundDuration(e.self))
+		}
+	}
+	return tw.Flush()
+}
+
+// roundDuration rounds a duration for a report.
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
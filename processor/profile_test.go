@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	tpl := `{{ define "fib" }}{{ if lt . 2 }}{{ . }}{{ else }}{{ template "fib" (sub . 1) }}` +
+		`{{ template "fib" (sub . 2) }}{{ end }}{{ end }}` + "\n" +
+		`{{ template "fib" 4 }}{{ .Gtpl.Add 1 2 }}{{ add 3 4 }}`
+	p := New(&Opts{AllowAliases: true, Profile: true})
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := p.ProcessStreams(strings.NewReader(tpl), &buf); err != nil {
+			t.Fatalf("ProcessStreams(...) = %v, need nil error", err)
+		}
+	}
+
+	pr := p.Profile()
+	for _, test := range []struct {
+		table   map[string]*profileEntry
+		name    string
+		wantCnt int
+	}{
+		{pr.templates, topLevel, 2},
+		{pr.templates, "fib", 18},
+		{pr.builtins, "sub", 16},
+		{pr.builtins, "add", 4}, // Both by full name and by alias
+		{pr.lines, "stdin:1", 32},
+		{pr.lines, "stdin:2", 6},
+	} {
+		e, ok := test.table[test.name]
+		if !ok {
+			t.Errorf("Profile() has no entry for %q", test.name)
+			continue
+		}
+		if e.calls != test.wantCnt {
+			t.Errorf("Profile() has %v calls of %q, want %v", e.calls, test.name, test.wantCnt)
+		}
+		if e.self > e.total {
+			t.Errorf("Profile() has self time %v for %q, which exceeds its total %v", e.self, test.name, e.total)
+		}
+	}
+
+	// Recursive calls don't add to the total.
+	if fib, top := pr.templates["fib"], pr.templates[topLevel]; fib.total > top.total {
+		t.Errorf("Profile() has total time %v for \"fib\", which exceeds the total %v", fib.total, top.total)
+	}
+
+	var buf bytes.Buffer
+	if err := pr.Report(&buf); err != nil {
+		t.Fatalf("Report(...) = %v, need nil error", err)
+	}
+	for _, want := range []string{"template     calls", "(top level)  2 ", "fib          18 ", "sub      16 ",
+		"stdin:1  32 "} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Report(...) wrote:\n%v\nwhich doesn't hold %q", buf.String(), want)
+		}
+	}
+
+	if got := New(&Opts{}).Profile(); got != nil {
+		t.Errorf("Profile() without Opts.Profile = %v, want nil", got)
+	}
+}
+
+func TestProfileOfFailure(t *testing.T) {
+	// Calls that don't end because execution fails are ended with the execution.
+	p := New(&Opts{AllowAliases: true, Profile: true})
+	var buf bytes.Buffer
+	tpl := `{{ define "x" }}{{ die "dead" }}{{ end }}{{ template "x" }}`
+	if err := p.ProcessStreams(strings.NewReader(tpl), &buf); err == nil {
+		t.Fatalf("ProcessStreams(%q) = nil, need error", tpl)
+	}
+	for _, name := range []string{topLevel, "x"} {
+		if e := p.Profile().templates[name]; e == nil || e.calls != 1 {
+			t.Errorf("Profile() after failing has entry %v for %q, want 1 call", e, name)
+		}
+	}
+}
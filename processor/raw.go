@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// expandRaw replaces the blocks between "{{ raw }}" and "{{ endraw }}" (using the given delimiters) by actions that
+// print their content byte for byte, so that the content isn't parsed. Lines stay where they are, so that locations in
+// errors remain valid. When a block lacks its end, the offset of its start is returned along with the error.
+//
+// Trim markers work as for other actions: "{{- raw" and "endraw -}}" trim the text around the block, "raw -}}" and
+// "{{- endraw" trim the content of the block.
+func expandRaw(text, left, right string) (string, int, error) {
+	begin := regexp.MustCompile(regexp.QuoteMeta(left) + `(-\s)?\s*raw\s*?(\s-)?` + regexp.QuoteMeta(right))
+	end := regexp.MustCompile(regexp.QuoteMeta(left) + `(-\s)?\s*endraw\s*?(\s-)?` + regexp.QuoteMeta(right))
+	var out strings.Builder
+	offset := 0
+	for {
+		b := begin.FindStringSubmatchIndex(text[offset:])
+		if b == nil {
+			break
+		}
+		e := end.FindStringSubmatchIndex(text[offset+b[1]:])
+		if e == nil {
+			return "", offset + b[0], fmt.Errorf("raw without endraw")
+		}
+		content := text[offset+b[1] : offset+b[1]+e[0]]
+		before, after := "", ""
+		if b[5] >= 0 {
+			trimmed := strings.TrimLeft(content, trimSpace)
+			before = content[:len(content)-len(trimmed)]
+			content = trimmed
+		}
+		if e[3] >= 0 {
+			trimmed := strings.TrimRight(content, trimSpace)
+			after = content[len(trimmed):]
+			content = trimmed
+		}
+
+		// Trimmed newlines stay in the action, so that lines don't move.
+		out.WriteString(text[offset : offset+b[0]])
+		out.WriteString(left)
+		if b[3] >= 0 {
+			out.WriteString("-")
+		}
+		out.WriteString(" print " + newlines(before) + rawLiteral(content) + newlines(after) + " ")
+		if e[5] >= 0 {
+			out.WriteString("-")
+		}
+		out.WriteString(right)
+		offset += b[1] + e[1]
+	}
+	out.WriteString(text[offset:])
+	return out.String(), 0, nil
+}
+
+// trimSpace is the white space that trim markers remove, as in text/template.
+const trimSpace = " \t\r\n"
+
+// newlines returns the newlines of s.
+func newlines(s string) string {
+	return strings.Repeat("\n", strings.Count(s, "\n"))
+}
+
+// rawLiteral returns string literals for text/template that evaluate to s, when given to print. Raw strings are used
+// so that newlines remain; backquotes and carriage returns, which raw strings can't hold, are quoted.
+func rawLiteral(s string) string {
+	var lits []string
+	for s != "" {
+		i := strings.IndexAny(s, "`\r")
+		if i < 0 {
+			lits = append(lits, "`"+s+"`")
+			break
+		}
+		if i > 0 {
+			lits = append(lits, "`"+s[:i]+"`")
+		}
+		lits = append(lits, strconv.Quote(s[i:i+1]))
+		s = s[i+1:]
+	}
+	if len(lits) == 0 {
+		return `""`
+	}
+	return strings.Join(lits, " ")
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRaw(t *testing.T) {
+	for _, test := range []struct {
+		left      string
+		right     string
+		input     string
+		want      string
+		wantError string
+	}{
+		{
+			input: "{{ $x := 1 }}{{ raw }}{{ $x }}{{ endraw }} {{ $x }}",
+			want:  "{{ $x }} 1",
+		},
+		{
+			input: "{{raw}}\n  {{ if }} `quoted` }}\r\n{{ {{endraw}}\n{{ raw }}{{ endraw }}|",
+			want:  "\n  {{ if }} `quoted` }}\r\n{{ \n|",
+		},
+		{
+			input: "{{ define \"chart\" }}{{ raw }}{{ .Values.x }}{{ endraw }}{{ end }}{{ template \"chart\" }}",
+			want:  "{{ .Values.x }}",
+		},
+		{
+			left:  "<<",
+			right: ">>",
+			input: "<< raw >><< x >> {{ y }}<< endraw >> {{ raw }}",
+			want:  "<< x >> {{ y }} {{ raw }}",
+		},
+		{
+			// Trim markers around the block trim the text around it.
+			input: "x {{- raw -}} {{ y }} {{- endraw -}} z",
+			want:  "x{{ y }}z",
+		},
+		{
+			input: "x\n{{- raw }} {{ y }} {{ endraw -}}\nz",
+			want:  "x {{ y }} z",
+		},
+		{
+			// Trim markers inside the block trim its content, lines stay where they are.
+			input:     "{{ raw -}}\n  {{ y }}\n{{- endraw }}|\n{{ die \"stop\" }}",
+			wantError: "stdin:4:3: ",
+		},
+		{
+			input: "{{ raw -}}\n  {{ y }}\n{{- endraw }}|",
+			want:  "{{ y }}|",
+		},
+		{
+			input:     "line 1\n  {{ raw }}\n",
+			wantError: "stdin:2:2: raw without endraw",
+		},
+		{
+			input:     "{{ raw }}{{ endraw }}\n{{ die \"stop\" }}",
+			wantError: "stdin:2:3: ",
+		},
+	} {
+		p := New(&Opts{AllowAliases: true, LeftDelimiter: test.left, RightDelimter: test.right})
+		var buf bytes.Buffer
+		err := p.ProcessStreams(strings.NewReader(test.input), &buf)
+		if test.wantError != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantError) {
+				t.Errorf("ProcessStreams(%q) = %v, want error with prefix %q", test.input, err, test.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ProcessStreams(%q) = %v, need nil error", test.input, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("ProcessStreams(%q) wrote %q, want %q", test.input, buf.String(), test.want)
+		}
+	}
+}
+
+func TestRawInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "lib.tpl", "{{ raw }}{{ .Values.x }}{{ endraw }}")
+	writeFile(t, dir, "open.tpl", "\n {{ raw }}")
+	for _, test := range []struct {
+		main      string
+		want      string
+		wantError string
+	}{
+		{
+			main: `{{ include "lib.tpl" }}`,
+			want: "{{ .Values.x }}",
+		},
+		{
+			main:      `{{ include "open.tpl" }}`,
+			wantError: filepath.Join(dir, "open.tpl") + ":2:1: raw without endraw",
+		},
+	} {
+		main := writeFile(t, dir, "main.tpl", test.main)
+		var buf bytes.Buffer
+		p := New(&Opts{AllowAliases: true})
+		err := p.ProcessFiles([]string{main}, &buf)
+		switch {
+		case test.wantError != "" && (err == nil || !strings.HasSuffix(err.Error(), test.wantError)):
+			t.Errorf("ProcessFiles(%q) = %v, want error ending in %q", test.main, err, test.wantError)
+		case test.wantError == "" && err != nil:
+			t.Errorf("ProcessFiles(%q) = %v, need nil error", test.main, err)
+		case test.wantError == "" && buf.String() != test.want:
+			t.Errorf("ProcessFiles(%q) wrote %q, want %q", test.main, buf.String(), test.want)
+		}
+	}
+}
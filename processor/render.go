@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ErrNoTemplate is the error of Render for a name that is not a template.
+var ErrNoTemplate = errors.New("no such template")
+
+// Renderer holds the templates of a directory, which are parsed once and can then be rendered repeatedly with
+// different data. Rendering may happen concurrently, e.g. for the requests of a server.
+type Renderer struct {
+	p         *Processor
+	data      map[interface{}]interface{} // Loaded data files
+	templates map[string]*compiled        // Templates by name
+}
+
+// Renderer parses the templates in a directory tree: files ending in .tpl, which are named by their path relative to
+// the directory, without the extension (e.g. "ssh/config" for dir/ssh/config.tpl). Each template is preceded by the
+// prelude files, just as with ProcessEach. The data files of the options are loaded once. Front matter "output" is
+// ignored.
+func (p *Processor) Renderer(prelude []string, dir string) (*Renderer, error) {
+	pre, err := readFiles(prelude)
+	if err != nil {
+		return nil, err
+	}
+	data, err := loadData(p.o.DataFiles)
+	if err != nil {
+		return nil, err
+	}
+	base, preParts, err := p.parse(template.New(preludeTemplate).Funcs(p.fmap), preludeTemplate, pre, nil, pre.stack())
+	if err != nil {
+		return nil, preParts.fix(err)
+	}
+
+	rd := &Renderer{
+		p:         p,
+		data:      data,
+		templates: map[string]*compiled{},
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, templateExt) {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		c, err := p.compile(base, pre, preParts, []string{path})
+		if err != nil {
+			return err
+		}
+		rd.templates[filepath.ToSlash(strings.TrimSuffix(rel, templateExt))] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Names returns the names of the templates, sorted.
+func (rd *Renderer) Names() []string {
+	var names []string
+	for name := range rd.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render renders a template by name. The data is added to `.Data`, after the data files and front matter, but before
+// the overrides of the options. The output goes to an io.Writer; "file" is not supported. Rendering stops when the
+// context is done.
+func (rd *Renderer) Render(ctx context.Context, name string, data map[string]interface{}, w io.Writer) error {
+	c, ok := rd.templates[name]
+	if !ok {
+		return fmt.Errorf("%v: %w", name, ErrNoTemplate)
+	}
+	r, err := rd.p.executeCompiled(ctx, c, rd.data, data, w)
+	if err != nil {
+		return err
+	}
+	if len(r.files) > 0 {
+		return fmt.Errorf("%v: writing files is not supported when rendering", name)
+	}
+	return nil
+}
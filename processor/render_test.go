@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRenderer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "hosts"), 0755); err != nil {
+		t.Fatalf("os.Mkdir(...) = %v, need nil error", err)
+	}
+	pre := writeFile(t, dir, "pre.gtpl", `{{- $domain := "example.com" -}}`)
+	writeFile(t, dir, "hosts/ssh.tpl", "Host {{ .Data.host }}.{{ $domain }} {{ getval .Data \"user\" }}\n")
+	writeFile(t, dir, "motd.tpl", "--- gtpl\ndata:\n  msg: hello\n---\n{{ .Data.msg }} {{ setkeyval .Data \"msg\" \"changed\" }}\n")
+	writeFile(t, dir, "files.tpl", `{{ file "x" }}x{{ endfile }}`)
+	writeFile(t, dir, "notes.txt", "not a template")
+
+	p := New(&Opts{AllowAliases: true, Values: []string{"user=root"}})
+	rd, err := p.Renderer([]string{pre}, dir)
+	if err != nil {
+		t.Fatalf("Renderer(...) = _,%v, need nil error", err)
+	}
+	if want := []string{"files", "hosts/ssh", "motd"}; !reflect.DeepEqual(rd.Names(), want) {
+		t.Errorf("Names() = %v, want %v", rd.Names(), want)
+	}
+
+	for _, test := range []struct {
+		name      string
+		data      map[string]interface{}
+		want      string
+		wantError bool
+	}{
+		{
+			// Overrides of the options win over the data of a request.
+			name: "hosts/ssh",
+			data: map[string]interface{}{"host": "alpha", "user": "alice"},
+			want: "Host alpha.example.com root\n",
+		},
+		{
+			// Front matter provides defaults, which requests override. Changes don't carry over.
+			name: "motd",
+			want: "hello \n",
+		},
+		{
+			name: "motd",
+			data: map[string]interface{}{"msg": "hi"},
+			want: "hi \n",
+		},
+		{
+			name:      "files",
+			wantError: true,
+		},
+	} {
+		var buf bytes.Buffer
+		err := rd.Render(context.Background(), test.name, test.data, &buf)
+		if gotError := err != nil; gotError != test.wantError {
+			t.Errorf("Render(%v, %v) = %v, want error: %v", test.name, test.data, err, test.wantError)
+		}
+		if !test.wantError && buf.String() != test.want {
+			t.Errorf("Render(%v, %v) wrote %q, want %q", test.name, test.data, buf.String(), test.want)
+		}
+	}
+	if err := rd.Render(context.Background(), "nope", nil, &bytes.Buffer{}); !errors.Is(err, ErrNoTemplate) {
+		t.Errorf("Render(nope, ...) = %v, want %v", err, ErrNoTemplate)
+	}
+
+	// Templates can be rendered concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			host := fmt.Sprintf("h%v", i)
+			if err := rd.Render(context.Background(), "hosts/ssh", map[string]interface{}{"host": host}, &buf); err != nil {
+				t.Errorf("Render(hosts/ssh, %v) = %v, need nil error", host, err)
+			}
+			if want := "Host " + host + ".example.com root\n"; buf.String() != want {
+				t.Errorf("Render(hosts/ssh, %v) wrote %q, want %q", host, buf.String(), want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRendererErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.tpl", "ok\n{{ if }}")
+	p := New(&Opts{AllowAliases: true})
+	if _, err := p.Renderer(nil, dir); err == nil {
+		t.Errorf("Renderer(nil, %v) = _,nil, want error", dir)
+	}
+}
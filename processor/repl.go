@@ -0,0 +1,258 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	replName         = "repl"      // Name of the input of the REPL in error messages
+	replVarsTemplate = "repl-vars" // Name of the template that declares the variables of a REPL session
+	replContinued    = "... "      // Prompt for lines that continue an unfinished snippet
+)
+
+// replDecl matches a REPL line that declares or assigns a variable, such as "$x := list 1 2".
+var replDecl = regexp.MustCompile(`^\s*(\$\w+)\s*:?=`)
+
+// session is the state of a REPL: the template set that holds the definitions, and the variables.
+type session struct {
+	p        *Processor
+	set      *template.Template
+	execute  func(t *template.Template, w io.Writer) error // Executes a parsed line
+	names    []string                                      // Names of the variables (such as "$x") in order of declaration
+	commands string                                        // Known commands, for errors
+	vars     map[string]interface{}                        // Values of the variables
+	w        io.Writer
+}
+
+// newSession returns a session that parses into a template set, and that has variables.
+func (p *Processor) newSession(set *template.Template, vars map[string]interface{}, w io.Writer) *session {
+	s := &session{
+		p:        p,
+		vars:     map[string]interface{}{},
+		commands: ":builtins, :vars and :load FILE",
+		w:        w,
+	}
+	for name, v := range vars {
+		s.names = append(s.names, name)
+		s.vars[name] = v
+	}
+	s.set = set.Funcs(template.FuncMap{
+		"replget":  func(name string) interface{} { return s.vars[name] },
+		"replset":  s.store,
+		"replshow": s.show,
+	})
+	return s
+}
+
+// REPL reads template snippets line by line and executes them with the Syringe and data of the processor, which
+// persist between lines, just like definitions and variables that are declared or assigned at the top level:
+//   - A line without delimiters is a pipeline, such as `list 1 2`. Its value is shown with its type, e.g.
+//     "[1 2] (list)". A declaration such as `$x := list 1 2` shows the value of the variable.
+//   - Other lines are snippets, such as `{{ range $x }}{{ . }}{{ end }}`, their output is shown. A snippet that is
+//     unfinished continues on the next line.
+//   - ":builtins" lists the builtins, ":vars" lists the variables and ":load FILE" executes a file as a snippet.
+//
+// Errors are shown, but don't stop the REPL; it stops at the end of the input. The prompt is shown before each line,
+// when not "".
+func (p *Processor) REPL(r io.Reader, w io.Writer, prompt string) error {
+	data, err := loadData(p.o.DataFiles)
+	if err != nil {
+		return err
+	}
+	if err := setValues(data, p.o.Values); err != nil {
+		return err
+	}
+	s := p.newSession(template.New(replName).Funcs(p.fmap), nil, w)
+	s.execute = func(t *template.Template, w io.Writer) error {
+		r, err := p.exec(context.Background(), t, p.needle, data, false, w)
+		if err != nil {
+			return err
+		}
+		return r.write()
+	}
+	return s.read(bufio.NewScanner(r), prompt, func(line string) (bool, error) {
+		return false, s.line(line)
+	})
+}
+
+// read reads lines and handles them, until the end of the input or until handle says to stop. The lines of an
+// unfinished snippet are joined. Errors are shown, but don't stop reading. The prompt is shown before each line, when
+// not "".
+func (s *session) read(scanner *bufio.Scanner, prompt string, handle func(line string) (bool, error)) error {
+	pending := ""
+	for {
+		if prompt != "" {
+			if pending == "" {
+				fmt.Fprint(s.w, prompt)
+			} else {
+				fmt.Fprint(s.w, replContinued)
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if pending != "" {
+			line = pending + "\n" + line
+		}
+		pending = ""
+		stop, err := handle(line)
+		if err != nil && unfinished(err) {
+			pending = line
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(s.w, "error: %v\n", err)
+		}
+		if stop {
+			return nil
+		}
+	}
+	if pending != "" {
+		fmt.Fprintf(s.w, "error: unfinished snippet at end of input\n")
+	}
+	return scanner.Err()
+}
+
+// unfinished returns whether a parse error means that a snippet isn't finished, e.g. when "end" is missing.
+func unfinished(err error) bool {
+	return strings.Contains(err.Error(), "unexpected EOF") || strings.Contains(err.Error(), "unclosed action")
+}
+
+// line handles a line of input: a command, a pipeline or a snippet.
+func (s *session) line(line string) error {
+	left, right := s.p.delims(segment{})
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch {
+	case strings.TrimSpace(line) == "":
+		return nil
+	case cmd == ":builtins":
+		fmt.Fprint(s.w, s.p.Overview())
+		return nil
+	case cmd == ":vars":
+		for _, name := range s.sortedVars() {
+			fmt.Fprintf(s.w, "%v = %v\n", name, s.show(s.vars[name]))
+		}
+		return nil
+	case cmd == ":load":
+		name := strings.TrimSpace(arg)
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		src := &source{}
+		if err := src.add(name, b); err != nil {
+			return err
+		}
+		return s.run(src)
+	case strings.HasPrefix(cmd, ":"):
+		return fmt.Errorf("unknown command %v, known are %v", cmd, s.commands)
+	case strings.Contains(line, left):
+		return s.runText(line, "", "")
+	}
+
+	// A pipeline is shown with its type.
+	if m := replDecl.FindStringSubmatch(line); m != nil {
+		return s.runText(line, left, right+left+"replshow "+m[1]+right)
+	}
+	return s.runText(line, left+"replshow (", ")"+right)
+}
+
+// runText runs a line between a prefix and a suffix. The prefix has no newlines, so that errors still state the
+// columns in the line.
+func (s *session) runText(line, prefix, suffix string) error {
+	src := &source{}
+	if err := src.add(replName, []byte(line+suffix)); err != nil {
+		return err
+	}
+	return s.run(src.prefixed(prefix))
+}
+
+// run parses a source into the session and executes it. The variables of the session are declared before the source,
+// and variables that the source declares or assigns at the top level are stored when execution passes them.
+func (s *session) run(src *source) error {
+	left, right := s.p.delims(segment{})
+	t, ps, err := s.p.parse(s.set, replName, src, s.names, src.stack())
+	if err != nil {
+		return ps.fix(err)
+	}
+	for _, n := range t.Tree.Root.Nodes {
+		a, ok := n.(*parse.ActionNode)
+		if !ok || len(a.Pipe.Decl) != 1 {
+			continue
+		}
+		name := a.Pipe.Decl[0].Ident[0]
+		a.Pipe.Cmds = append(a.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      a.Position(),
+			Args: []parse.Node{
+				parse.NewIdentifier("replset").SetTree(t.Tree).SetPos(a.Position()),
+				&parse.StringNode{
+					NodeType: parse.NodeString,
+					Pos:      a.Position(),
+					Quoted:   fmt.Sprintf("%q", name),
+					Text:     name,
+				},
+			},
+		})
+		if _, ok := s.vars[name]; !ok {
+			s.names = append(s.names, name)
+			s.vars[name] = nil
+		}
+	}
+
+	// Declare the variables with their values, before the nodes of the source.
+	decls := ""
+	for _, name := range s.names {
+		decls += fmt.Sprintf("%v%v := replget %q%v", left, name, name, right)
+	}
+	vt, err := s.set.New(replVarsTemplate).Delims(left, right).Parse(decls)
+	if err != nil {
+		return err
+	}
+	t.Tree.Root.Nodes = append(append([]parse.Node(nil), vt.Tree.Root.Nodes...), t.Tree.Root.Nodes...)
+
+	var buf strings.Builder
+	err = s.execute(t, &buf)
+	out := buf.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	fmt.Fprint(s.w, out)
+	return ps.fix(err)
+}
+
+// store stores the value of a variable, it returns the value so that it can end a pipeline.
+func (s *session) store(name string, v interface{}) interface{} {
+	s.vars[name] = v
+	return v
+}
+
+// show returns a value with its type, e.g. "[1 2] (list)". Values that the "type" builtin doesn't know get their Go
+// type. The root of the data (the dot of the main template) shows its data.
+func (s *session) show(v interface{}) string {
+	if inj, ok := v.(*injected); ok {
+		return fmt.Sprintf("{.Gtpl .Data:%v} (root)", inj.Data)
+	}
+	t, err := s.p.needle.Type(v)
+	if err != nil {
+		t = fmt.Sprintf("%T", v)
+	}
+	return fmt.Sprintf("%v (%v)", v, t)
+}
+
+// sortedVars returns the names of the variables, sorted.
+func (s *session) sortedVars() []string {
+	names := append([]string(nil), s.names...)
+	sort.Strings(names)
+	return names
+}
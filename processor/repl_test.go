@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", `{{ define "hi" }}hello {{ . }}{{ end }}{{ $greeting := "hey" }}`)
+	input := strings.Join([]string{
+		`list 1 2`,
+		`$l := list 1 2 3`,
+		`contains $l 2`,
+		`$n := 3`,
+		`$n = add $n 1`,
+		`"a string"`,
+		`{{ range $l }}`,
+		`{{ . }},{{ end }}`,
+		`:load ` + lib,
+		`{{ template "hi" $greeting }}`,
+		`.Data.app`,
+		`:vars`,
+		`nosuch 1`,
+		`:nope`,
+		`{{ if`,
+	}, "\n")
+	want := strings.Join([]string{
+		`[1 2] (list)`,
+		`[1 2 3] (list)`,
+		`true (bool)`,
+		`3 (int)`,
+		`4 (int)`,
+		`a string (string)`,
+		``,
+		`1,`,
+		`2,`,
+		`3,`,
+		`hello hey`,
+		`demo (string)`,
+		`$greeting = hey (string)`,
+		`$l = [1 2 3] (list)`,
+		`$n = 4 (int)`,
+		`error: repl:1: function "nosuch" not defined`,
+		`error: unknown command :nope, known are :builtins, :vars and :load FILE`,
+		`error: unfinished snippet at end of input`,
+		``,
+	}, "\n")
+	p := New(&Opts{AllowAliases: true, Values: []string{"app=demo"}})
+	var buf bytes.Buffer
+	if err := p.REPL(strings.NewReader(input), &buf, ""); err != nil {
+		t.Fatalf("REPL(...) = %v, need nil error", err)
+	}
+	if buf.String() != want {
+		t.Errorf("REPL(%q) wrote:\n%v\nwant:\n%v", input, buf.String(), want)
+	}
+}
+
+func TestREPLPrompt(t *testing.T) {
+	p := New(&Opts{AllowAliases: true})
+	var buf bytes.Buffer
+	if err := p.REPL(strings.NewReader("{{ if true }}\nyes{{ end }}\n"), &buf, "> "); err != nil {
+		t.Fatalf("REPL(...) = %v, need nil error", err)
+	}
+	if want := "> ... \nyes\n> "; buf.String() != want {
+		t.Errorf("REPL(...) wrote %q, want %q", buf.String(), want)
+	}
+}
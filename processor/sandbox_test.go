@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KarelKubat/gtpl/syringe"
+)
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", `{{ env "HOME" }}`)
+	for _, test := range []struct {
+		tpl       string
+		aliases   bool
+		permit    []syringe.Capability
+		wantError string // "" when processing succeeds
+	}{
+		{
+			tpl:     `{{ map "a" 1 }}`,
+			aliases: true,
+		},
+		{
+			tpl:       "a\n  {{ env \"HOME\" }}",
+			aliases:   true,
+			wantError: `stdin:2:5: env is not permitted in the sandbox, it needs capability "env"`,
+		},
+		{
+			tpl:       `{{ define "x" }}{{ .Gtpl.Log "hi" }}{{ end }}`,
+			wantError: `stdin:1:19: log is not permitted in the sandbox, it needs capability "log"`,
+		},
+		{
+			tpl:       `{{ include "` + lib + `" }}`,
+			aliases:   true,
+			wantError: `stdin:1:3: include is not permitted in the sandbox, it needs capability "read"`,
+		},
+		{
+			// Included files are checked too.
+			tpl:       `{{ include "` + lib + `" }}`,
+			aliases:   true,
+			permit:    []syringe.Capability{syringe.CapRead},
+			wantError: lib + `:1:3: env is not permitted in the sandbox, it needs capability "env"`,
+		},
+		{
+			tpl:     `{{ include "` + lib + `" }}`,
+			aliases: true,
+			permit:  []syringe.Capability{syringe.CapRead, syringe.CapEnv},
+		},
+		{
+			// Builtins that are reached through .Gtpl in other ways are denied when they are called. Those that can't
+			// return an error do nothing, and fail at the end.
+			tpl:       `{{ with .Gtpl }}{{ .Env "HOME" }}{{ end }}`,
+			wantError: `env is not permitted in the sandbox, it needs capability "env"`,
+		},
+		{
+			tpl:       `{{ $g := .Gtpl }}{{ $g.Log "leak" }}`,
+			wantError: `log is not permitted in the sandbox, it needs capability "log"`,
+		},
+		{
+			tpl:       `{{ with .Gtpl }}{{ .File "` + filepath.Join(dir, "pwned.txt") + `" }}x{{ .EndFile }}{{ end }}`,
+			wantError: `stdin:1:19: executing "gtpl" at <.File>: error calling File: file is not permitted in the sandbox, it needs capability "write"`,
+		},
+		{
+			tpl:       `{{ $g := .Gtpl }}{{ $g.Include "` + lib + `" }}`,
+			wantError: `stdin:1:22: executing "gtpl" at <$g.Include>: error calling Include: include is not permitted in the sandbox, it needs capability "read"`,
+		},
+		{
+			tpl:    `{{ with .Gtpl }}{{ .Env "HOME" }}{{ end }}`,
+			permit: []syringe.Capability{syringe.CapEnv},
+		},
+		{
+			tpl:       "--- gtpl\noutput: " + filepath.Join(dir, "out") + "\n---\n",
+			wantError: "front matter output " + filepath.Join(dir, "out") + ` is not permitted in the sandbox, it needs capability "write"`,
+		},
+	} {
+		p := New(&Opts{AllowAliases: test.aliases, Sandbox: true, Permit: test.permit})
+		var buf bytes.Buffer
+		err := p.ProcessStreams(strings.NewReader(test.tpl), &buf)
+		switch {
+		case err != nil && err.Error() != test.wantError:
+			t.Errorf("ProcessStreams(%q) = %v, want error %q", test.tpl, err, test.wantError)
+		case err == nil && test.wantError != "":
+			t.Errorf("ProcessStreams(%q) = nil, want error %q", test.tpl, test.wantError)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned.txt")); err == nil {
+		t.Errorf("ProcessStreams(...) in a sandbox wrote a file using \"file\"")
+	}
+}
@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	t.Setenv("GTPL_TEST_SET", "set")
+	os.Unsetenv("GTPL_TEST_UNSET")
+	for _, test := range []struct {
+		tpl        string
+		aliases    bool
+		wantStrict string // Output, or error when strict
+		wantLoose  string // Output when not strict
+	}{
+		{
+			tpl:        `{{ $m := map "a" 1 }}{{ getval $m "a" }}`,
+			aliases:    true,
+			wantStrict: "1",
+			wantLoose:  "1",
+		},
+		{
+			tpl:        "{{ $m := map \"a\" 1 }}\n{{ getval $m \"b\" }}",
+			aliases:    true,
+			wantStrict: `stdin:2:3: executing "gtpl" at <getval $m "b">: error calling getval: getval: no key "b", keys are: a`,
+			wantLoose:  "\n",
+		},
+		{
+			tpl:        `{{ $m := .Gtpl.Map "a" 1 }}{{ .Gtpl.GetVal $m "b" }}`,
+			wantStrict: `stdin:1:35: executing "gtpl" at <.Gtpl.GetVal>: error calling GetVal: getval: no key "b", keys are: a`,
+		},
+		{
+			tpl:        `{{ getvaldefault (map "a" 1) "b" 2 }}`,
+			aliases:    true,
+			wantStrict: "2",
+			wantLoose:  "2",
+		},
+		{
+			tpl:        `{{ env "GTPL_TEST_SET" }} {{ env "GTPL_TEST_UNSET" }}`,
+			aliases:    true,
+			wantStrict: `stdin:1:29: executing "gtpl" at <env "GTPL_TEST_UNSET">: error calling env: env: GTPL_TEST_UNSET is not set`,
+			wantLoose:  "set ",
+		},
+		{
+			tpl:        `{{ .Data.nosuchkey }}`,
+			wantStrict: `stdin:1:8: executing "gtpl" at <.Data.nosuchkey>: map has no entry for key "nosuchkey"`,
+			wantLoose:  "<no value>",
+		},
+	} {
+		for _, strict := range []bool{true, false} {
+			want := test.wantLoose
+			if strict {
+				want = test.wantStrict
+			}
+			p := New(&Opts{AllowAliases: test.aliases, Strict: strict})
+			var buf bytes.Buffer
+			got := ""
+			if err := p.ProcessStreams(strings.NewReader(test.tpl), &buf); err != nil {
+				got = err.Error()
+			} else {
+				got = buf.String()
+			}
+			if got != want {
+				t.Errorf("ProcessStreams(%q) with strict %v = %q, want %q", test.tpl, strict, got, want)
+			}
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	for _, test := range []struct {
+		tpl       string
+		tracing   bool
+		keepGoing bool
+		want      string // Error
+	}{
+		{
+			tpl:  `{{ getvalstrict (map "a" 1) "a" }}`,
+			want: `stdin:1: function "getvalstrict" not defined`,
+		},
+		{
+			tpl:  `{{ checkat "x" true }}`,
+			want: `stdin:1: function "checkat" not defined`,
+		},
+		{
+			tpl:  `{{ traceat "x" "getval" "GetVal" (map "a" 1) "a" }}`,
+			want: `stdin:1: function "traceat" not defined`,
+		},
+		{
+			tpl:  `{{ check }}`,
+			want: `stdin:1:3: executing "gtpl" at <check>: wrong number of args for check: want at least 1 got 0`,
+		},
+		{
+			tpl:       `{{ check }}`,
+			keepGoing: true,
+			want:      `stdin:1:3: executing "gtpl" at <check>: wrong number of args for check: want at least 1 got 0`,
+		},
+		{
+			tpl:  `{{ getval (map "a" 1) }}`,
+			want: `stdin:1:3: executing "gtpl" at <getval>: wrong number of args for getval: want 2 got 1`,
+		},
+		{
+			tpl:     `{{ getval (map "a" 1) "b" }}`,
+			tracing: true,
+			want:    `stdin:1:3: executing "gtpl" at <getval (map "a" 1) "b">: error calling getval: getval: no key "b", keys are: a`,
+		},
+		{
+			tpl:     `{{ check }}`,
+			tracing: true,
+			want:    `stdin:1:3: executing "gtpl" at <check>: error calling check: check: wrong number of arguments, want at least 1, got 0`,
+		},
+	} {
+		p := New(&Opts{AllowAliases: true, Strict: true, Trace: test.tracing, KeepGoing: test.keepGoing, Logger: &bufLogger{&bytes.Buffer{}}})
+		var buf bytes.Buffer
+		err := p.ProcessStreams(strings.NewReader(test.tpl), &buf)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("ProcessStreams(%q) with tracing %v, keep going %v = %v, want %q", test.tpl, test.tracing,
+				test.keepGoing, err, test.want)
+		}
+	}
+}
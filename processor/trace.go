@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"errors"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+)
+
+// untraced are the builtins whose calls aren't traced: the tracers and breakpoints, which are rewritten later (see
+// addBreakpoints).
+var untraced = map[string]bool{
+	"TraceAt":      true,
+	"TraceEnter":   true,
+	"TraceExit":    true,
+	"Breakpoint":   true,
+	"BreakpointAt": true,
+}
+
+// traceCall rewrites a call of a builtin to a call of "traceat", which logs or measures it: `getval $h "port"` becomes
+// `traceat "file:1:5" "getval" "GetVal" $h "port"`. The builtin that's invoked may be a variant of the call, such as
+// "GetValStrict"; variants that get the position, get it from "traceat".
+func (p *Processor) traceCall(t *template.Template, cmd *parse.CommandNode, loc, call, variant string) {
+	pos := cmd.Position()
+	cmd.Args = append([]parse.Node{
+		parse.NewIdentifier("traceat").SetTree(t.Tree).SetPos(pos),
+		stringNode(pos, loc),
+		stringNode(pos, call),
+		stringNode(pos, variant),
+	}, cmd.Args[1:]...)
+}
+
+// traceTemplates rewrites the calls of templates in a list (and in the lists that it holds) so that they are traced:
+// `{{ template "x" . }}` becomes `{{ template "x" (traceenter "file:1:5" "x" .) }}{{ traceexit "file:1:5" "x" }}`.
+// Calls that are already rewritten are left alone.
+func (p *Processor) traceTemplates(t *template.Template, ps parts, l *parse.ListNode) {
+	if l == nil {
+		return
+	}
+	var nodes []parse.Node
+	for _, n := range l.Nodes {
+		nodes = append(nodes, n)
+		switch n := n.(type) {
+		case *parse.IfNode:
+			p.traceTemplates(t, ps, n.List)
+			p.traceTemplates(t, ps, n.ElseList)
+		case *parse.RangeNode:
+			p.traceTemplates(t, ps, n.List)
+			p.traceTemplates(t, ps, n.ElseList)
+		case *parse.WithNode:
+			p.traceTemplates(t, ps, n.List)
+			p.traceTemplates(t, ps, n.ElseList)
+		case *parse.TemplateNode:
+			if calls(n.Pipe, "traceenter") {
+				continue
+			}
+			p.rewritten.add(n, 0)
+			loc, _ := t.ErrorContext(n)
+			loc = ps.fix(errors.New(loc)).Error()
+			enter := tracerCall(t, n.Position(), n.Line, "traceenter", loc, n.Name)
+			if n.Pipe != nil {
+				enter.Cmds[0].Args = append(enter.Cmds[0].Args, n.Pipe)
+			}
+			n.Pipe = enter
+			nodes = append(nodes, &parse.ActionNode{
+				NodeType: parse.NodeAction,
+				Pos:      n.Position(),
+				Line:     n.Line,
+				Pipe:     tracerCall(t, n.Position(), n.Line, "traceexit", loc, n.Name),
+			})
+		}
+	}
+	l.Nodes = nodes
+}
+
+// tracerCall returns a pipeline that calls a tracer with the position and the name of a template.
+func tracerCall(t *template.Template, pos parse.Pos, line int, tracer, loc, name string) *parse.PipeNode {
+	return &parse.PipeNode{
+		NodeType: parse.NodePipe,
+		Pos:      pos,
+		Line:     line,
+		Cmds: []*parse.CommandNode{{
+			NodeType: parse.NodeCommand,
+			Pos:      pos,
+			Args: []parse.Node{
+				parse.NewIdentifier(tracer).SetTree(t.Tree).SetPos(pos),
+				stringNode(pos, loc),
+				stringNode(pos, name),
+			},
+		}},
+	}
+}
+
+// stringNode returns a node that holds a string constant.
+func stringNode(pos parse.Pos, text string) *parse.StringNode {
+	return &parse.StringNode{
+		NodeType: parse.NodeString,
+		Pos:      pos,
+		Quoted:   strconv.Quote(text),
+		Text:     text,
+	}
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type lineLogger struct {
+	lines []string
+}
+
+func (l *lineLogger) Print(v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func TestTrace(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		opts    Opts
+		tpl     string
+		want    string   // Output
+		wantLog []string // Logged lines
+	}{
+		{
+			name: "builtins and templates",
+			opts: Opts{AllowAliases: true},
+			tpl: `{{ define "host" }}{{ getval . "port" }}{{ end }}` + "\n" +
+				`{{ $h := map "name" "alpha" }}{{ template "host" $h }}{{ if true }}{{ template "host" }}{{ end }}.`,
+			want: "\n.",
+			wantLog: []string{
+				`gtpl: trace: stdin:2:9: map "name" "alpha" = map[name:alpha]`,
+				`gtpl: trace: stdin:2:42: template "host" with map[name:alpha]`,
+				`gtpl: trace: stdin:1:22: getval map[name:alpha] "port" = ""`,
+				`gtpl: trace: stdin:2:42: end of template "host"`,
+				`gtpl: trace: stdin:2:79: template "host"`,
+				`gtpl: trace: stdin:1:22: getval <nil> "port" = ""`,
+				`gtpl: trace: stdin:2:79: end of template "host"`,
+			},
+		},
+		{
+			name: "full names and variants",
+			opts: Opts{Strict: true, KeepGoing: true, Values: []string{"a=1"}},
+			tpl:  `{{ .Gtpl.Check false "no port" }}{{ .Gtpl.GetVal .Data "a" }}`,
+			want: "1",
+			wantLog: []string{
+				`gtpl: trace: stdin:1:3: .Gtpl.Check false "no port" = ""`,
+				`gtpl: trace: stdin:1:36: .Gtpl.GetVal map[a:1] "a" = 1`,
+			},
+		},
+	} {
+		l := &lineLogger{}
+		test.opts.Trace = true
+		test.opts.Logger = l
+		p := New(&test.opts)
+		var buf bytes.Buffer
+		err := p.ProcessStreams(strings.NewReader(test.tpl), &buf)
+		if test.opts.KeepGoing {
+			// The check fails at the end, with its position.
+			if want := "stdin:1:3: check: no port"; err == nil || err.Error() != want {
+				t.Errorf("%v: ProcessStreams(...) = %v, want error %q", test.name, err, want)
+			}
+		} else if err != nil {
+			t.Errorf("%v: ProcessStreams(...) = %v, want nil error", test.name, err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%v: ProcessStreams(...) wrote %q, want %q", test.name, buf.String(), test.want)
+		}
+		if !reflect.DeepEqual(l.lines, test.wantLog) {
+			t.Errorf("%v: ProcessStreams(...) logged:\n%v\nwant:\n%v", test.name, strings.Join(l.lines, "\n"),
+				strings.Join(test.wantLog, "\n"))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// fileState is what's known about a watched file; it changes when the file is modified, created or removed.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// Inputs returns the files that were read during the last run: input files, data files and included files.
+func (p *Processor) Inputs() []string {
+	return append([]string(nil), p.inputs...)
+}
+
+// Watch calls render, which is expected to run this processor (e.g. using ProcessToFile). Then it polls the inputs of
+// that run, and calls render again when any of them changes. The outcome of each render is passed to report, so that
+// errors are shown without stopping. Watch runs until the context is done.
+func (p *Processor) Watch(ctx context.Context, interval time.Duration, render func() error, report func(error)) error {
+	for {
+		// The inputs may change with each run (e.g., when includes are added). Files that were known before
+		// rendering keep their earlier state, so that changes during the run are seen.
+		before := snapshot(p.inputs)
+		report(render())
+		states := snapshot(p.inputs)
+		for f, st := range before {
+			if _, ok := states[f]; ok {
+				states[f] = st
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		for changed := false; !changed; {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return ctx.Err()
+			case <-ticker.C:
+				changed = !sameStates(states, snapshot(p.inputs))
+			}
+		}
+		ticker.Stop()
+	}
+}
+
+// snapshot returns the states of files.
+func snapshot(files []string) map[string]fileState {
+	states := map[string]fileState{}
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			states[f] = fileState{}
+			continue
+		}
+		states[f] = fileState{
+			exists:  true,
+			size:    st.Size(),
+			modTime: st.ModTime(),
+		}
+	}
+	return states
+}
+
+// sameStates is true when two snapshots are the same.
+func sameStates(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, st := range a {
+		if other, ok := b[f]; !ok || other != st {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInputs(t *testing.T) {
+	dir := t.TempDir()
+	lib := writeFile(t, dir, "lib.tpl", "lib")
+	data := writeFile(t, dir, "data.yaml", "a: 1\n")
+	main := writeFile(t, dir, "main.tpl", `{{ include "lib.tpl" }}`)
+	p := New(&Opts{AllowAliases: true, DataFiles: []string{"d=" + data}})
+	if err := p.ProcessFiles([]string{main}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("ProcessFiles(...) = %v, need nil error", err)
+	}
+	want := []string{main, data, lib}
+	got := p.Inputs()
+	if len(got) != len(want) {
+		t.Fatalf("Inputs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Inputs()[%v] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	in := writeFile(t, dir, "in.tpl", "one")
+	out := filepath.Join(dir, "out")
+	p := New(&Opts{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reports := make(chan error)
+	go func() {
+		err := p.Watch(ctx, 10*time.Millisecond, func() error {
+			return p.ProcessToFile([]string{in}, out)
+		}, func(err error) {
+			reports <- err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Watch(...) = %v, want %v", err, context.Canceled)
+		}
+		close(reports)
+	}()
+
+	if err := <-reports; err != nil {
+		t.Fatalf("Watch(...): first run reported %v, need nil error", err)
+	}
+	for i, test := range []struct {
+		content   string
+		wantError bool
+	}{
+		{
+			content:   "{{ if }}",
+			wantError: true,
+		},
+		{
+			content:   "three",
+			wantError: false,
+		},
+	} {
+		// Make sure that the modification time changes, even on file systems with a coarse resolution.
+		writeFile(t, dir, "in.tpl", test.content)
+		future := time.Now().Add(time.Duration(i+1) * time.Minute)
+		if err := os.Chtimes(in, future, future); err != nil {
+			t.Fatalf("os.Chtimes(%q) = %v, need nil error", in, err)
+		}
+		err := <-reports
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("Watch(...) after writing %q: reported %v, want error: %v", test.content, err, test.wantError)
+		}
+	}
+	if b, _ := os.ReadFile(out); string(b) != "three" {
+		t.Errorf("after Watch(...): output is %q, want %q", string(b), "three")
+	}
+	cancel()
+	for range reports {
+	}
+}
+
+func TestWatchFirstRunFails(t *testing.T) {
+	dir := t.TempDir()
+	in := writeFile(t, dir, "in.tpl", "--- gtpl\nno: closing marker\n")
+	out := filepath.Join(dir, "out")
+	p := New(&Opts{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reports := make(chan error)
+	go func() {
+		p.Watch(ctx, 10*time.Millisecond, func() error {
+			return p.ProcessToFile([]string{in}, out)
+		}, func(err error) {
+			reports <- err
+		})
+		close(reports)
+	}()
+
+	if err := <-reports; err == nil {
+		t.Fatalf("Watch(...): first run reported nil, need error")
+	}
+	writeFile(t, dir, "in.tpl", "fixed")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(in, future, future); err != nil {
+		t.Fatalf("os.Chtimes(%q) = %v, need nil error", in, err)
+	}
+	if err := <-reports; err != nil {
+		t.Errorf("Watch(...) after fixing the input: reported %v, want nil error", err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "fixed" {
+		t.Errorf("after Watch(...): output is %q, want %q", string(b), "fixed")
+	}
+	cancel()
+	for range reports {
+	}
+}
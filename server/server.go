@@ -0,0 +1,83 @@
+// Package server renders the templates of a directory over HTTP.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/KarelKubat/gtpl/processor"
+)
+
+const (
+	renderPrefix = "/render/"   // Path prefix for rendering, followed by the name of a template
+	listPath     = "/templates" // Path that lists the templates
+	maxBody      = 1 << 20      // Maximum size of a request body
+)
+
+// Server is an http.Handler that renders templates:
+//   - POST /render/NAME renders template NAME. The body is a JSON object, which is added to .Data. The response is the
+//     output; when rendering fails, the status is 422 and the response is the error.
+//   - GET /templates lists the names of the templates, one per line.
+type Server struct {
+	rd *processor.Renderer
+}
+
+// New returns a Server for the templates of a Renderer.
+func New(rd *processor.Renderer) *Server {
+	return &Server{
+		rd: rd,
+	}
+}
+
+// ServeHTTP satisfies http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == listPath:
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, name := range s.rd.Names() {
+			fmt.Fprintln(w, name)
+		}
+	case strings.HasPrefix(r.URL.Path, renderPrefix):
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.render(w, r, strings.TrimPrefix(r.URL.Path, renderPrefix))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// render renders a template with the data of a request. The output is collected first, so that a failure can still
+// set the status.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string) {
+	data := map[string]interface{}{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.UseNumber() // Numbers become ints or floats, just as in data files
+	if err := dec.Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := s.rd.Render(r.Context(), name, data, &buf); err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, processor.ErrNoTemplate) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
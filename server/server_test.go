@@ -0,0 +1,106 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KarelKubat/gtpl/processor"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh.tpl"), []byte("Host {{ .Data.host }}\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(...) = %v, need nil error", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "port.tpl"), []byte("{{ type .Data.port }} {{ add .Data.port 1 }}"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(...) = %v, need nil error", err)
+	}
+	rd, err := processor.New(&processor.Opts{AllowAliases: true, Strict: true}).Renderer(nil, dir)
+	if err != nil {
+		t.Fatalf("Renderer(nil, %v) = _,%v, need nil error", dir, err)
+	}
+	ts := httptest.NewServer(New(rd))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string // Start of the body
+	}{
+		{
+			method:     http.MethodGet,
+			path:       "/templates",
+			wantStatus: http.StatusOK,
+			wantBody:   "port\nssh\n",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/render/ssh",
+			body:       `{"host": "alpha"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "Host alpha\n",
+		},
+		{
+			// Numbers get the same types as in data files.
+			method:     http.MethodPost,
+			path:       "/render/port",
+			body:       `{"port": 22}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "int 23",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/render/ssh",
+			body:       `{}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   filepath.Join(dir, "ssh.tpl") + ":1:13: ",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/render/ssh",
+			body:       `["not", "an", "object"]`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "request body: ",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/render/nope",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "nope: no such template",
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/render/ssh",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/",
+			wantStatus: http.StatusNotFound,
+		},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("http.NewRequest(...) = _,%v, need nil error", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", test.method, test.path, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.wantStatus {
+			t.Errorf("%v %v: status %v, want %v", test.method, test.path, resp.StatusCode, test.wantStatus)
+		}
+		if !strings.HasPrefix(string(b), test.wantBody) {
+			t.Errorf("%v %v: body %q, want it to start with %q", test.method, test.path, string(b), test.wantBody)
+		}
+	}
+}
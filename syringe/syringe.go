@@ -1,6 +1,7 @@
 package syringe
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -30,29 +31,99 @@ type Logger interface {
 	Print(v ...interface{})
 }
 
+// Includer renders the templates that are pulled in using "include". It is set by the caller that parses templates,
+// see package processor.
+type Includer interface {
+	Include(name string, data ...interface{}) (string, error)
+}
+
+// Router sends output to separate files for "file" and "endfile". It is set by the caller that executes templates,
+// see package processor.
+type Router interface {
+	Begin(name string) error
+	End() error
+}
+
+// Debugger inspects the state of execution at a breakpoint: the position in the template, the dot and the variables
+// that are in scope (by name, such as "$x"). An error stops execution. It is set by the caller that executes
+// templates, see package processor.
+type Debugger interface {
+	Break(pos string, dot interface{}, vars map[string]interface{}) error
+}
+
+// Profiler measures the calls of builtins and templates. Calls nest, End ends the call that began last. It is set by
+// the caller that executes templates, see package processor.
+type Profiler interface {
+	BeginBuiltin(pos, call string)
+	BeginTemplate(pos, name string)
+	End()
+}
+
+// Capability is a kind of side effect that builtins have. In a sandbox, builtins that need a capability are denied
+// unless it is permitted.
+type Capability string
+
+const (
+	CapEnv   Capability = "env"   // Reading environment variables: env, envdefault
+	CapLog   Capability = "log"   // Writing to the log: log, warn
+	CapRead  Capability = "read"  // Reading files: include, import
+	CapWrite Capability = "write" // Writing files: file, endfile
+)
+
+// Capabilities are all capabilities, see ParseCapability.
+var Capabilities = []Capability{CapEnv, CapLog, CapRead, CapWrite}
+
 // Syringe is the receiver of the template functions injector.
 type Syringe struct {
-	logger   Logger
-	logUsed  bool
-	builtins []Builtin
+	logger    Logger
+	logUsed   bool
+	keepGoing bool
+	sandbox   bool
+	permits   map[Capability]bool
+	maxLoop   int
+	tracing   bool
+	ctx       context.Context
+	failures  []string
+	includer  Includer
+	router    Router
+	debugger  Debugger
+	profiler  Profiler
+	builtins  []Builtin
 }
 
 // Opts are the options for New.
 type Opts struct {
-	Logger Logger // Used for "log" statements, defaults to https://pkg.go.dev/log
+	Logger    Logger       // Used for "log" statements, defaults to https://pkg.go.dev/log
+	KeepGoing bool         // When true, "assert" and "die" record failures (see Failures) instead of stopping
+	Sandbox   bool         // When true, builtins with side effects are denied, except for those that Permit allows
+	Permit    []Capability // Capabilities that are allowed in a sandbox
+	MaxLoop   int          // Maximum number of iterations of Loop and LoopLimited, 0 means no limit
+	Trace     bool         // When true, TraceAt, TraceEnter and TraceExit log the calls that they get
 }
 
 type Builtin struct {
-	function interface{}
-	Name     string
-	Alias    string
-	Usage    string
+	function   interface{}
+	needs      Capability // Side effect of the builtin, "" when it has none
+	positioned bool       // When true, the first argument is the position of the call in the template
+	variant    bool       // When true, callers rewrite calls to the builtin, templates don't call it by name
+	Name       string
+	Alias      string
+	Usage      string
 }
 
 // New returns an initialized Syringe.
 func New(o *Opts) *Syringe {
 	s := &Syringe{
-		logger: o.Logger,
+		logger:    o.Logger,
+		keepGoing: o.KeepGoing,
+		sandbox:   o.Sandbox,
+		permits:   map[Capability]bool{},
+		maxLoop:   o.MaxLoop,
+		tracing:   o.Trace,
+		ctx:       context.Background(),
+	}
+	for _, c := range o.Permit {
+		s.permits[c] = true
 	}
 	if s.logger == nil {
 		s.logger = log.Default()
@@ -73,6 +144,7 @@ func New(o *Opts) *Syringe {
 		},
 		{
 			function: s.Log,
+			needs:    CapLog,
 			Name:     "Log",
 			Alias:    "log",
 			Usage:    `{{ log "some" "info" }} - sends args to the log`,
@@ -85,16 +157,148 @@ func New(o *Opts) *Syringe {
 		},
 		{
 			function: s.Env,
+			needs:    CapEnv,
 			Name:     "Env",
 			Alias:    "env",
 			Usage:    `my homedir is {{ env "HOME" }} - returns environment setting`,
 		},
+		{
+			function: s.EnvDefault,
+			needs:    CapEnv,
+			Name:     "EnvDefault",
+			Alias:    "envdefault",
+			Usage:    `{{ envdefault "EDITOR" "vi" }} - returns environment setting, or the default when it's unset`,
+		},
 		{
 			function: s.Assert,
 			Name:     "Assert",
 			Alias:    "assert",
 			Usage:    `asserts a condition and stops if not met: {{ assert (len $list) gt 0) "list is empty!" }}`,
 		},
+		{
+			function: s.Check,
+			Name:     "Check",
+			Alias:    "check",
+			Usage: `checks a condition, records a failure if not met but continues: {{ check (haskey $h "port") "no port" }}` +
+				"\n" + `processing fails at the end when any check failed`,
+		},
+		{
+			function: s.Warn,
+			needs:    CapLog,
+			Name:     "Warn",
+			Alias:    "warn",
+			Usage:    `{{ warn "port" $port "is deprecated" }} - logs a warning with the position, continues`,
+		},
+		// Variants that fail instead of returning "", for strict mode. Callers that parse templates rewrite calls of
+		// getval and env to these (see package processor), they have no usage info.
+		{
+			function: s.GetValStrict,
+			variant:  true,
+			Name:     "GetValStrict",
+			Alias:    "getvalstrict",
+		},
+		{
+			function: s.EnvStrict,
+			needs:    CapEnv,
+			variant:  true,
+			Name:     "EnvStrict",
+			Alias:    "envstrict",
+		},
+		// Variants that get the position in the template as first argument. Callers that parse templates rewrite
+		// calls of assert, die, check and warn to these (see package processor), they have no usage info.
+		{
+			function:   s.AssertAt,
+			positioned: true,
+			variant:    true,
+			Name:       "AssertAt",
+			Alias:      "assertat",
+		},
+		{
+			function:   s.DieAt,
+			positioned: true,
+			variant:    true,
+			Name:       "DieAt",
+			Alias:      "dieat",
+		},
+		{
+			function:   s.CheckAt,
+			positioned: true,
+			variant:    true,
+			Name:       "CheckAt",
+			Alias:      "checkat",
+		},
+		{
+			function:   s.WarnAt,
+			needs:      CapLog,
+			positioned: true,
+			variant:    true,
+			Name:       "WarnAt",
+			Alias:      "warnat",
+		},
+		// Variant of breakpoint that gets the position, the dot and the variables in scope. Callers that parse
+		// templates rewrite calls of breakpoint to this when debugging (see package processor).
+		{
+			function:   s.BreakpointAt,
+			positioned: true,
+			variant:    true,
+			Name:       "BreakpointAt",
+			Alias:      "breakpointat",
+		},
+		{
+			function: s.Breakpoint,
+			Name:     "Breakpoint",
+			Alias:    "breakpoint",
+			Usage:    `{{ breakpoint }} - when debugging, pauses and opens an inspector for the dot and the variables`,
+		},
+		// Builtins that log or measure what execution does. Callers that parse templates rewrite calls of builtins and
+		// templates to these when tracing or profiling (see package processor), they have no usage info.
+		{
+			function: s.TraceAt,
+			variant:  true,
+			Name:     "TraceAt",
+			Alias:    "traceat",
+		},
+		{
+			function: s.TraceEnter,
+			variant:  true,
+			Name:     "TraceEnter",
+			Alias:    "traceenter",
+		},
+		{
+			function: s.TraceExit,
+			variant:  true,
+			Name:     "TraceExit",
+			Alias:    "traceexit",
+		},
+		{
+			function: s.Include,
+			needs:    CapRead,
+			Name:     "Include",
+			Alias:    "include",
+			Usage: `{{ include "lib/ssh.tpl" }} - expands another file here, its definitions become available` + "\n" +
+				`{{ include "lib/host.tpl" $host }} - same, but with $host as the dot`,
+		},
+		{
+			function: s.Import,
+			needs:    CapRead,
+			Name:     "Import",
+			Alias:    "import",
+			Usage:    `{{ import "lib/defs.tpl" }} - makes the definitions of another file available, expands nothing`,
+		},
+		{
+			function: s.File,
+			needs:    CapWrite,
+			Name:     "File",
+			Alias:    "file",
+			Usage:    `{{ file "hosts/ws.conf" }} ... {{ endfile }} - sends the output in-between to a separate file`,
+		},
+		{
+			function: s.EndFile,
+			needs:    CapWrite,
+			Name:     "EndFile",
+			Alias:    "endfile",
+			Usage:    `{{ endfile }} - ends the output to a file that was started using "file"`,
+		},
 
 		// Strings
 		{
@@ -153,6 +357,12 @@ func New(o *Opts) *Syringe {
 			Alias:    "getval",
 			Usage:    `a cat says {{ get $map "cat" }} - gets a value from a map, "" if absent`,
 		},
+		{
+			function: s.GetValDefault,
+			Name:     "GetValDefault",
+			Alias:    "getvaldefault",
+			Usage:    `a frog says {{ getvaldefault $map "frog" "nothing" }} - gets a value from a map, or the default if absent`,
+		},
 		{
 			function: s.SetKeyVal,
 			Name:     "SetKeyVal",
@@ -237,6 +447,14 @@ func New(o *Opts) *Syringe {
 			Alias:    "loop",
 			Usage:    `1 up to and including 10: {{ range $i := loop 1 11 }} {{ $i }} {{ end }}`,
 		},
+		// Variant that fails when there are too many iterations. Callers that parse templates rewrite calls of loop
+		// to this when Opts.MaxLoop is set (see package processor), it has no usage info.
+		{
+			function: s.LoopLimited,
+			variant:  true,
+			Name:     "LoopLimited",
+			Alias:    "looplimited",
+		},
 	}
 	sort.Slice(s.builtins, func(i, j int) bool {
 		return s.builtins[i].Name < s.builtins[j].Name
@@ -246,11 +464,27 @@ func New(o *Opts) *Syringe {
 }
 
 // AliasesMap returns a `template.FuncMap` that can be passed to text/template so that shorthand
-// builtins can be used.
+// builtins can be used. The variants of builtins are left out, see Variants.
 func (s *Syringe) AliasesMap() template.FuncMap {
 	fmap := template.FuncMap{}
 	for _, b := range s.builtins {
-		fmap[b.Alias] = b.function
+		if !b.variant {
+			fmap[b.Alias] = b.function
+		}
+	}
+	return fmap
+}
+
+// Variants returns a `template.FuncMap` of the variants of builtins (e.g. "getvalstrict" and "checkat") by alias.
+// Callers that parse templates rewrite calls to these, and add them to the functions of a template after parsing, so
+// that templates can't call them by name. This is a function and not a method, so that templates can't reach it
+// through .Gtpl.
+func Variants(s *Syringe) template.FuncMap {
+	fmap := template.FuncMap{}
+	for _, b := range s.builtins {
+		if b.variant {
+			fmap[b.Alias] = b.function
+		}
 	}
 	return fmap
 }
@@ -260,6 +494,66 @@ func (s *Syringe) Builtins() []Builtin {
 	return s.builtins
 }
 
+// ParseCapability returns the capability with a name, e.g. "env".
+func ParseCapability(name string) (Capability, error) {
+	for _, c := range Capabilities {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown capability %q, known are: %v", name, Capabilities)
+}
+
+// Permits returns whether a capability is available: always, unless the Syringe is a sandbox.
+func (s *Syringe) Permits(c Capability) bool {
+	return !s.sandbox || s.permits[c]
+}
+
+// Permitted returns an error when a builtin (by name, e.g. "Env") is denied because the Syringe is a sandbox. Denied
+// builtins fail when they are called, however templates reach them: builtins that return an error (e.g. Include)
+// return this one, the others (e.g. Env) do nothing and record it as a failure, see Failures. Callers that parse
+// templates may also check the builtins that a template calls before executing it, see package processor.
+func (s *Syringe) Permitted(name string) error {
+	for _, b := range s.builtins {
+		if b.Name == name && b.needs != "" && !s.Permits(b.needs) {
+			return fmt.Errorf("%v is not permitted in the sandbox, it needs capability %q", b.Alias, b.needs)
+		}
+	}
+	return nil
+}
+
+// allowed returns whether a builtin that can't return an error may run. When it's denied, that's recorded as a
+// failure.
+func (s *Syringe) allowed(name string) bool {
+	if err := s.Permitted(name); err != nil {
+		s.fail("", err.Error())
+		return false
+	}
+	return true
+}
+
+// Execution connects a Syringe to the caller that executes templates, see Attach.
+type Execution struct {
+	Context  context.Context // Loop stops when it is done, when nil context.Background()
+	Includer Includer        // Renders files for Include
+	Router   Router          // Sends output to files for File and EndFile
+	Debugger Debugger        // Inspects the state at BreakpointAt, without one breakpoints do nothing
+	Profiler Profiler        // Told about calls by TraceAt, TraceEnter and TraceExit
+}
+
+// Attach connects a Syringe to an execution of templates, replacing what it was connected to. This is a function and
+// not a method, so that templates can't reach it through .Gtpl.
+func Attach(s *Syringe, e Execution) {
+	s.ctx = e.Context
+	if s.ctx == nil {
+		s.ctx = context.Background()
+	}
+	s.includer = e.Includer
+	s.router = e.Router
+	s.debugger = e.Debugger
+	s.profiler = e.Profiler
+}
+
 // Builtin functions.
 // Remember to update the above info when adding/modifying!
 
@@ -277,34 +571,357 @@ func (s *Syringe) Version() string {
 
 // Log is the builtin that logs information using the `log.Print` function.
 func (s *Syringe) Log(args ...interface{}) string {
+	if !s.allowed("Log") {
+		return ""
+	}
 	s.logUsed = true
+	s.log(join(args))
+	return ""
+}
+
+// log sends a message to the logger.
+func (s *Syringe) log(msg string) {
+	s.logger.Print(fmt.Sprintf("%s: %s", expanderName, msg))
+}
+
+// join returns the args separated by spaces.
+func join(args []interface{}) string {
 	parts := make([]string, len(args))
 	for i, a := range args {
 		parts[i] = fmt.Sprintf("%v", a)
 	}
-	s.logger.Print(fmt.Sprintf("%s: %s", expanderName, strings.Join(parts, " ")))
-	return ""
+	return strings.Join(parts, " ")
 }
 
 // Die is the builtin that stops execution. If previous `Log` invocations occurred, then the
 // the reason for stopping is logged, else, the reason is shown on `os.Stderr`.
 func (s *Syringe) Die(args ...interface{}) (string, error) {
+	return s.DieAt("", args...)
+}
+
+// DieAt is Die at a position in the template. When keeping going, the reason is recorded as a failure instead of
+// stopping.
+func (s *Syringe) DieAt(pos string, args ...interface{}) (string, error) {
 	msg := fmt.Sprint(args...)
 	if s.logUsed {
-		s.Log(msg)
+		s.log(msg)
+	}
+	if s.keepGoing {
+		s.fail(pos, msg)
+		return "", nil
 	}
 	return "", errors.New(msg)
 }
 
 // Env is the builtin that fetches the value of an environment variable.
 func (s *Syringe) Env(str string) string {
+	if !s.allowed("Env") {
+		return ""
+	}
 	return os.Getenv(str)
 }
 
+// EnvDefault is the builtin that fetches the value of an environment variable, or a default when it's unset.
+func (s *Syringe) EnvDefault(str, def string) string {
+	if !s.allowed("EnvDefault") {
+		return ""
+	}
+	if val, ok := os.LookupEnv(str); ok {
+		return val
+	}
+	return def
+}
+
+// EnvStrict is Env that fails when the variable is unset.
+func (s *Syringe) EnvStrict(str string) (string, error) {
+	if err := s.Permitted("Env"); err != nil {
+		return "", err
+	}
+	val, ok := os.LookupEnv(str)
+	if !ok {
+		return "", fmt.Errorf("env: %v is not set", str)
+	}
+	return val, nil
+}
+
 // Assert is the builtin that ensures a condition.
 func (s *Syringe) Assert(cond bool, args ...interface{}) (string, error) {
+	return s.AssertAt("", cond, args...)
+}
+
+// AssertAt is Assert at a position in the template. When keeping going, an unmet condition is recorded as a failure
+// instead of stopping.
+func (s *Syringe) AssertAt(pos string, cond bool, args ...interface{}) (string, error) {
+	if cond {
+		return "", nil
+	}
+	msg := fmt.Sprintf("assert: %v", fmt.Sprint(args...))
+	if s.keepGoing {
+		s.fail(pos, msg)
+		return "", nil
+	}
+	return "", errors.New(msg)
+}
+
+// Check is the builtin that checks a condition. When it isn't met, a failure is recorded, but execution continues.
+func (s *Syringe) Check(cond bool, args ...interface{}) string {
+	return s.CheckAt("", cond, args...)
+}
+
+// CheckAt is Check at a position in the template.
+func (s *Syringe) CheckAt(pos string, cond bool, args ...interface{}) string {
 	if !cond {
-		return "", fmt.Errorf("assert: %v", fmt.Sprint(args...))
+		s.fail(pos, fmt.Sprintf("check: %v", join(args)))
+	}
+	return ""
+}
+
+// Warn is the builtin that logs a warning, execution continues.
+func (s *Syringe) Warn(args ...interface{}) string {
+	return s.WarnAt("", args...)
+}
+
+// WarnAt is Warn at a position in the template.
+func (s *Syringe) WarnAt(pos string, args ...interface{}) string {
+	if !s.allowed("Warn") {
+		return ""
+	}
+	if pos != "" {
+		pos += ": "
+	}
+	s.log(fmt.Sprintf("%swarning: %s", pos, join(args)))
+	return ""
+}
+
+// fail records a failure at a position in the template, which may be "".
+func (s *Syringe) fail(pos, msg string) {
+	if pos != "" {
+		msg = pos + ": " + msg
+	}
+	s.failures = append(s.failures, msg)
+}
+
+// Failures returns the failures that a Syringe recorded since the last call, by "check", by "assert" and "die" when
+// keeping going, or by builtins that the sandbox denies. The caller decides whether to fail. This is a function and not a method, so that templates can't
+// reach it through .Gtpl and discard their own failures.
+func Failures(s *Syringe) []string {
+	f := s.failures
+	s.failures = nil
+	return f
+}
+
+// Breakpoint is the builtin that pauses execution for inspection. It does nothing, unless callers rewrite calls to
+// BreakpointAt.
+func (s *Syringe) Breakpoint() string {
+	return ""
+}
+
+// BreakpointAt is Breakpoint at a position in the template, with the dot and the variables in scope as name/value
+// pairs. It passes these to the debugger.
+func (s *Syringe) BreakpointAt(pos string, dot interface{}, vars ...interface{}) (string, error) {
+	if s.debugger == nil {
+		return "", nil
+	}
+	m := map[string]interface{}{}
+	for i := 0; i+1 < len(vars); i += 2 {
+		m[fmt.Sprint(vars[i])] = vars[i+1]
+	}
+	return "", s.debugger.Break(pos, dot, m)
+}
+
+// TraceAt calls a builtin by name. When tracing, the call is logged with its position, arguments and result or error;
+// when profiling, the profiler is told when it begins and ends. The call is logged as written in the template (e.g.
+// "getval" or ".Gtpl.GetVal"), it may invoke a variant (e.g. "GetValStrict"). Variants that get the position of the
+// call (e.g. "CheckAt") get pos as first argument, which isn't logged.
+func (s *Syringe) TraceAt(pos, call, name string, args ...interface{}) (interface{}, error) {
+	if s.profiler != nil {
+		s.profiler.BeginBuiltin(pos, call)
+	}
+	out, err := s.invoke(pos, call, name, args)
+	if s.profiler != nil {
+		s.profiler.End()
+	}
+	if !s.tracing {
+		return out, err
+	}
+	parts := []string{call}
+	for _, a := range args {
+		parts = append(parts, traceValue(a))
+	}
+	if err != nil {
+		s.trace(pos, "%v failed: %v", strings.Join(parts, " "), err)
+		return nil, err
+	}
+	s.trace(pos, "%v = %v", strings.Join(parts, " "), traceValue(out))
+	return out, nil
+}
+
+// TraceEnter logs that a template is invoked, with the dot that it gets, and tells the profiler. It returns the dot, so
+// that it can be passed on to the template; without a dot it returns nil.
+func (s *Syringe) TraceEnter(pos, name string, dot ...interface{}) interface{} {
+	var d interface{}
+	if len(dot) > 0 {
+		d = dot[0]
+	}
+	switch {
+	case !s.tracing:
+	case len(dot) == 0:
+		s.trace(pos, "template %q", name)
+	default:
+		s.trace(pos, "template %q with %v", name, traceValue(d))
+	}
+	if s.profiler != nil {
+		s.profiler.BeginTemplate(pos, name)
+	}
+	return d
+}
+
+// TraceExit logs that a template has finished, and tells the profiler.
+func (s *Syringe) TraceExit(pos, name string) string {
+	if s.profiler != nil {
+		s.profiler.End()
+	}
+	if s.tracing {
+		s.trace(pos, "end of template %q", name)
+	}
+	return ""
+}
+
+// trace logs a line of a trace.
+func (s *Syringe) trace(pos, format string, args ...interface{}) {
+	s.logger.Print(fmt.Sprintf("%s: trace: %s: %s", expanderName, pos, fmt.Sprintf(format, args...)))
+}
+
+// traceValue formats a value for a trace, strings are quoted so that "" stands out. Channels (of "loop") are shown
+// by their type.
+func traceValue(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return fmt.Sprintf("%q", str)
+	}
+	if v != nil && reflect.TypeOf(v).Kind() == reflect.Chan {
+		return fmt.Sprintf("%T", v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// invoke calls a builtin by name. Arguments are converted as text/template would: e.g. numbers to the type of a
+// parameter, nil to its zero value. Errors name the call as written, and count its arguments without the position.
+func (s *Syringe) invoke(pos, call, name string, args []interface{}) (interface{}, error) {
+	var b *Builtin
+	for i := range s.builtins {
+		if s.builtins[i].Name == name {
+			b = &s.builtins[i]
+		}
+	}
+	if b == nil {
+		return nil, fmt.Errorf("no such builtin %q", name)
+	}
+	f := reflect.ValueOf(b.function)
+	ft := f.Type()
+	skip := 0 // Parameters that the call doesn't pass
+	if b.positioned {
+		skip = 1
+	}
+	switch want := ft.NumIn() - skip; {
+	case ft.IsVariadic() && len(args) < want-1:
+		return nil, fmt.Errorf("%v: wrong number of arguments, want at least %v, got %v", call, want-1, len(args))
+	case !ft.IsVariadic() && len(args) != want:
+		return nil, fmt.Errorf("%v: wrong number of arguments, want %v, got %v", call, want, len(args))
+	}
+	if b.positioned {
+		args = append([]interface{}{pos}, args...)
+	}
+	in := make([]reflect.Value, len(args))
+	for i, a := range args {
+		var t reflect.Type
+		if ft.IsVariadic() && i >= ft.NumIn()-1 {
+			t = ft.In(ft.NumIn() - 1).Elem()
+		} else {
+			t = ft.In(i)
+		}
+		v, err := argValue(a, t)
+		if err != nil {
+			return nil, fmt.Errorf("%v: argument %v: %v", call, i+1-skip, err)
+		}
+		in[i] = v
+	}
+	out := f.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+// argValue converts an argument to the type of a parameter.
+func argValue(a interface{}, t reflect.Type) (reflect.Value, error) {
+	if a == nil {
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("can't use nil as %v", t)
+	}
+	v := reflect.ValueOf(a)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if isNumber(v.Kind()) && isNumber(t.Kind()) {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("can't use %v (%T) as %v", a, a, t)
+}
+
+// isNumber returns whether a kind is an integer or a floating point number.
+func isNumber(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// Include is the builtin that expands another template file. The file is loaded before execution, by whoever parses
+// the template (see package processor); an optional argument is passed as the dot of the included template.
+func (s *Syringe) Include(name string, args ...interface{}) (string, error) {
+	if err := s.Permitted("Include"); err != nil {
+		return "", err
+	}
+	if len(args) > 1 {
+		return "", fmt.Errorf("include: %v: at most one data argument allowed, got %v", name, len(args))
+	}
+	if s.includer == nil {
+		return "", fmt.Errorf("include: %v: including is not supported here", name)
+	}
+	return s.includer.Include(name, args...)
+}
+
+// Import is the builtin that makes definitions of another template file available. Just as for Include, the file is
+// loaded before execution; at execution time there is nothing left to do.
+func (s *Syringe) Import(name string) string {
+	s.allowed("Import")
+	return ""
+}
+
+// File is the builtin that starts sending output to a separate file, until EndFile.
+func (s *Syringe) File(name string) (string, error) {
+	if err := s.Permitted("File"); err != nil {
+		return "", err
+	}
+	if s.router == nil {
+		return "", fmt.Errorf("file: %v: writing files is not supported here", name)
+	}
+	if err := s.router.Begin(name); err != nil {
+		return "", fmt.Errorf("file: %v", err)
+	}
+	return "", nil
+}
+
+// EndFile is the builtin that stops sending output to a separate file.
+func (s *Syringe) EndFile() (string, error) {
+	if err := s.Permitted("EndFile"); err != nil {
+		return "", err
+	}
+	if s.router == nil {
+		return "", errors.New("endfile: writing files is not supported here")
+	}
+	if err := s.router.End(); err != nil {
+		return "", fmt.Errorf("endfile: %v", err)
 	}
 	return "", nil
 }
@@ -399,6 +1016,27 @@ func (s *Syringe) GetVal(m map[interface{}]interface{}, key interface{}) interfa
 	return ""
 }
 
+// GetValDefault is the builtin that returns a value from a map given a key, or a default.
+func (s *Syringe) GetValDefault(m map[interface{}]interface{}, key, def interface{}) interface{} {
+	if val, ok := m[key]; ok {
+		return val
+	}
+	return def
+}
+
+// GetValStrict is GetVal that fails when the key is absent. The error lists the keys that the map has.
+func (s *Syringe) GetValStrict(m map[interface{}]interface{}, key interface{}) (interface{}, error) {
+	if val, ok := m[key]; ok {
+		return val, nil
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+	return nil, fmt.Errorf("getval: no key %q, keys are: %v", fmt.Sprint(key), strings.Join(keys, ", "))
+}
+
 // SetKeyVal is the builtin that sets a value for a key in a map, or adds it.
 func (s *Syringe) SetKeyVal(m map[interface{}]interface{}, key, val interface{}) string {
 	m[key] = val
@@ -666,14 +1304,41 @@ func (s *Syringe) Div(a, b interface{}) (interface{}, error) {
 	}
 }
 
-// Loop is like a list of increasing ints.
+// Loop is like a list of increasing ints. The list ends early when the context is done, see Attach. When there are
+// more iterations than Opts.MaxLoop, the list is empty and a failure is recorded (see Failures).
 func (s *Syringe) Loop(a, b int) <-chan int {
 	ch := make(chan int)
+	if err := s.loopLimit(a, b); err != nil {
+		s.fail("", err.Error())
+		close(ch)
+		return ch
+	}
+	done := s.ctx.Done()
 	go func() {
+		defer close(ch)
 		for i := a; i < b; i++ {
-			ch <- i
+			select {
+			case ch <- i:
+			case <-done:
+				return
+			}
 		}
-		close(ch)
 	}()
 	return ch
 }
+
+// LoopLimited is Loop that fails when there are more iterations than Opts.MaxLoop.
+func (s *Syringe) LoopLimited(a, b int) (<-chan int, error) {
+	if err := s.loopLimit(a, b); err != nil {
+		return nil, err
+	}
+	return s.Loop(a, b), nil
+}
+
+// loopLimit returns an error when a loop from a to b has more iterations than Opts.MaxLoop.
+func (s *Syringe) loopLimit(a, b int) error {
+	if s.maxLoop > 0 && b-a > s.maxLoop {
+		return fmt.Errorf("loop: %v iterations exceed the limit of %v", b-a, s.maxLoop)
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package syringe
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -17,6 +20,25 @@ func TestDocStrings(t *testing.T) {
 	}
 }
 
+func TestAliasesAndVariants(t *testing.T) {
+	s := New(&Opts{})
+	aliases := s.AliasesMap()
+	variants := Variants(s)
+	for _, b := range s.builtins {
+		_, isAlias := aliases[b.Alias]
+		_, isVariant := variants[b.Alias]
+		if isAlias == b.variant || isVariant != b.variant {
+			t.Errorf("%v: in AliasesMap() %v, in Variants() %v, want only in Variants() when a variant",
+				b.Alias, isAlias, isVariant)
+		}
+	}
+	for _, alias := range []string{"getvalstrict", "checkat", "traceat"} {
+		if _, ok := variants[alias]; !ok {
+			t.Errorf("Variants() lacks %q", alias)
+		}
+	}
+}
+
 func TestExpanderAndVersion(t *testing.T) {
 	s := New(&Opts{})
 	if s.Expander() != expanderName {
@@ -144,6 +166,43 @@ func TestGetAndSetVal(t *testing.T) {
 	}
 }
 
+func TestGetValDefaultAndStrict(t *testing.T) {
+	s := New(&Opts{})
+	m := s.Map("port", 22, "hostname", "alpha")
+	if v := s.GetValDefault(m, "port", 80); v != 22 {
+		t.Errorf("GetValDefault(%v, port, 80) = %v, want 22", m, v)
+	}
+	if v := s.GetValDefault(m, "user", "root"); v != "root" {
+		t.Errorf("GetValDefault(%v, user, root) = %v, want root", m, v)
+	}
+	if v, err := s.GetValStrict(m, "hostname"); err != nil || v != "alpha" {
+		t.Errorf("GetValStrict(%v, hostname) = %v, %v, want alpha, nil", m, v, err)
+	}
+	want := `getval: no key "hostnam", keys are: hostname, port`
+	if _, err := s.GetValStrict(m, "hostnam"); err == nil || err.Error() != want {
+		t.Errorf("GetValStrict(%v, hostnam) = _, %v, want error %q", m, err, want)
+	}
+}
+
+func TestEnvDefaultAndStrict(t *testing.T) {
+	s := New(&Opts{})
+	t.Setenv("GTPL_TEST_SET", "set")
+	os.Unsetenv("GTPL_TEST_UNSET")
+	if v := s.EnvDefault("GTPL_TEST_SET", "def"); v != "set" {
+		t.Errorf("EnvDefault(GTPL_TEST_SET, def) = %v, want set", v)
+	}
+	if v := s.EnvDefault("GTPL_TEST_UNSET", "def"); v != "def" {
+		t.Errorf("EnvDefault(GTPL_TEST_UNSET, def) = %v, want def", v)
+	}
+	if v, err := s.EnvStrict("GTPL_TEST_SET"); err != nil || v != "set" {
+		t.Errorf("EnvStrict(GTPL_TEST_SET) = %v, %v, want set, nil", v, err)
+	}
+	want := "env: GTPL_TEST_UNSET is not set"
+	if _, err := s.EnvStrict("GTPL_TEST_UNSET"); err == nil || err.Error() != want {
+		t.Errorf("EnvStrict(GTPL_TEST_UNSET) = _, %v, want error %q", err, want)
+	}
+}
+
 func TestIsKind(t *testing.T) {
 	s := New(&Opts{})
 	for _, test := range []struct {
@@ -186,3 +245,368 @@ func TestIsAndAssert(t *testing.T) {
 		t.Errorf("template.Execute(...) = %q, want nil error", err.Error())
 	}
 }
+
+type fakeIncluder struct{}
+
+func (f *fakeIncluder) Include(name string, data ...interface{}) (string, error) {
+	return strings.Join([]string{name, strings.Repeat("+", len(data))}, ":"), nil
+}
+
+func TestInclude(t *testing.T) {
+	s := New(&Opts{})
+	if _, err := s.Include("a.tpl"); err == nil {
+		t.Error("Include(...) without includer = _,nil, want error")
+	}
+	Attach(s, Execution{Includer: &fakeIncluder{}})
+	for _, test := range []struct {
+		args      []interface{}
+		want      string
+		wantError bool
+	}{
+		{
+			want: "a.tpl:",
+		},
+		{
+			args: []interface{}{1},
+			want: "a.tpl:+",
+		},
+		{
+			args:      []interface{}{1, 2},
+			wantError: true,
+		},
+	} {
+		got, err := s.Include("a.tpl", test.args...)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("Include(a.tpl, %v) = _,%v, want error: %v", test.args, err, test.wantError)
+		}
+		if got != test.want {
+			t.Errorf("Include(a.tpl, %v) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestFileWithoutRouter(t *testing.T) {
+	s := New(&Opts{})
+	if _, err := s.File("a"); err == nil {
+		t.Error("File(...) without router = _,nil, want error")
+	}
+	if _, err := s.EndFile(); err == nil {
+		t.Error("EndFile() without router = _,nil, want error")
+	}
+}
+
+type fakeLogger struct {
+	lines []string
+}
+
+func (f *fakeLogger) Print(v ...interface{}) {
+	f.lines = append(f.lines, fmt.Sprint(v...))
+}
+
+func TestCheckAndWarn(t *testing.T) {
+	l := &fakeLogger{}
+	s := New(&Opts{Logger: l})
+	s.Check(true, "fine")
+	s.CheckAt("a.tpl:1:2", false, "host", 2, "has no port")
+	s.Check(false, "no position")
+	s.WarnAt("a.tpl:3:4", "port", 22, "is deprecated")
+	want := []string{"a.tpl:1:2: check: host 2 has no port", "check: no position"}
+	if got := Failures(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Failures(s) = %q, want %q", got, want)
+	}
+	if got := Failures(s); got != nil {
+		t.Errorf("Failures(s) after Failures(s) = %q, want nil", got)
+	}
+	if want := []string{"gtpl: a.tpl:3:4: warning: port 22 is deprecated"}; !reflect.DeepEqual(l.lines, want) {
+		t.Errorf("WarnAt(...) logged %q, want %q", l.lines, want)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		s := New(&Opts{KeepGoing: keepGoing})
+		_, assertErr := s.AssertAt("a.tpl:1:2", false, "wrong")
+		_, dieErr := s.DieAt("a.tpl:3:4", "dead")
+		if gotErr := assertErr != nil; gotErr == keepGoing {
+			t.Errorf("with KeepGoing %v: AssertAt(...) = _,%v, want error: %v", keepGoing, assertErr, !keepGoing)
+		}
+		if gotErr := dieErr != nil; gotErr == keepGoing {
+			t.Errorf("with KeepGoing %v: DieAt(...) = _,%v, want error: %v", keepGoing, dieErr, !keepGoing)
+		}
+		var want []string
+		if keepGoing {
+			want = []string{"a.tpl:1:2: assert: wrong", "a.tpl:3:4: dead"}
+		}
+		if got := Failures(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("with KeepGoing %v: Failures(s) = %q, want %q", keepGoing, got, want)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	for _, test := range []struct {
+		opts       *Opts
+		name       string
+		wantDenied bool
+	}{
+		{opts: &Opts{}, name: "Env"},
+		{opts: &Opts{Sandbox: true}, name: "Env", wantDenied: true},
+		{opts: &Opts{Sandbox: true}, name: "EnvStrict", wantDenied: true},
+		{opts: &Opts{Sandbox: true}, name: "Include", wantDenied: true},
+		{opts: &Opts{Sandbox: true}, name: "File", wantDenied: true},
+		{opts: &Opts{Sandbox: true}, name: "Warn", wantDenied: true},
+		{opts: &Opts{Sandbox: true}, name: "Map"},
+		{opts: &Opts{Sandbox: true}, name: "Die"},
+		{opts: &Opts{Sandbox: true, Permit: []Capability{CapEnv}}, name: "Env"},
+		{opts: &Opts{Sandbox: true, Permit: []Capability{CapEnv}}, name: "Log", wantDenied: true},
+	} {
+		s := New(test.opts)
+		err := s.Permitted(test.name)
+		if gotDenied := err != nil; gotDenied != test.wantDenied {
+			t.Errorf("Permitted(%v) with %+v = %v, want denied: %v", test.name, test.opts, err, test.wantDenied)
+		}
+	}
+
+	// Denied builtins fail when they are called: with an error, or with a failure when they don't return errors.
+	t.Setenv("GTPL_TEST_SET", "set")
+	s := New(&Opts{Sandbox: true})
+	for name, call := range map[string]func() (string, error){
+		"EnvStrict": func() (string, error) { return s.EnvStrict("GTPL_TEST_SET") },
+		"Include":   func() (string, error) { return s.Include("a.tpl") },
+		"File":      func() (string, error) { return s.File("a") },
+		"EndFile":   s.EndFile,
+	} {
+		if _, err := call(); err == nil || !strings.Contains(err.Error(), "not permitted") {
+			t.Errorf("%v(...) in a sandbox = _,%v, want error that it's not permitted", name, err)
+		}
+	}
+	for name, call := range map[string]func() string{
+		"Log":        func() string { return s.Log("hi") },
+		"Env":        func() string { return s.Env("GTPL_TEST_SET") },
+		"EnvDefault": func() string { return s.EnvDefault("GTPL_TEST_SET", "") },
+		"WarnAt":     func() string { return s.WarnAt("a.tpl:1:2", "careful") },
+		"Import":     func() string { return s.Import("a.tpl") },
+	} {
+		if out := call(); out != "" {
+			t.Errorf("%v(...) in a sandbox = %q, want \"\"", name, out)
+		}
+		if f := Failures(s); len(f) != 1 || !strings.Contains(f[0], "not permitted") {
+			t.Errorf("%v(...) in a sandbox: failures %q, want a failure that it's not permitted", name, f)
+		}
+	}
+}
+
+func TestParseCapability(t *testing.T) {
+	for _, c := range Capabilities {
+		if got, err := ParseCapability(string(c)); err != nil || got != c {
+			t.Errorf("ParseCapability(%v) = %v,%v, want %v,nil", c, got, err, c)
+		}
+	}
+	if _, err := ParseCapability("exec"); err == nil {
+		t.Errorf("ParseCapability(exec) = _,nil, want error")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	s := New(&Opts{})
+	var got []int
+	for i := range s.Loop(1, 5) {
+		got = append(got, i)
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Loop(1, 5) = %v, want %v", got, want)
+	}
+
+	// With too many iterations, LoopLimited fails and Loop records a failure.
+	s = New(&Opts{MaxLoop: 3})
+	if _, err := s.LoopLimited(1, 4); err != nil {
+		t.Errorf("LoopLimited(1, 4) with MaxLoop 3 = _,%v, want nil error", err)
+	}
+	if _, err := s.LoopLimited(1, 5); err == nil {
+		t.Errorf("LoopLimited(1, 5) with MaxLoop 3 = _,nil, want error")
+	}
+	n := 0
+	for range s.Loop(1, 5) {
+		n++
+	}
+	want := []string{"loop: 4 iterations exceed the limit of 3"}
+	if f := Failures(s); n != 0 || !reflect.DeepEqual(f, want) {
+		t.Errorf("Loop(1, 5) with MaxLoop 3 ran %v times and recorded %q, want 0 times and %q", n, f, want)
+	}
+
+	// Loop ends when the context is done.
+	s = New(&Opts{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Attach(s, Execution{Context: ctx})
+	n = 0
+	for range s.Loop(0, 1000000000) {
+		n++
+	}
+	if n == 1000000000 {
+		t.Errorf("Loop(0, 1000000000) with a cancelled context ran %v times, want fewer", n)
+	}
+}
+
+type fakeDebugger struct {
+	pos  string
+	dot  interface{}
+	vars map[string]interface{}
+}
+
+func (f *fakeDebugger) Break(pos string, dot interface{}, vars map[string]interface{}) error {
+	f.pos, f.dot, f.vars = pos, dot, vars
+	return nil
+}
+
+func TestBreakpoint(t *testing.T) {
+	s := New(&Opts{})
+	if got := s.Breakpoint(); got != "" {
+		t.Errorf("Breakpoint() = %q, want \"\"", got)
+	}
+	if got, err := s.BreakpointAt("t:1:2", 1, "$x", 2); got != "" || err != nil {
+		t.Errorf("BreakpointAt(...) without a debugger = %q,%v, want \"\",nil", got, err)
+	}
+
+	d := &fakeDebugger{}
+	Attach(s, Execution{Debugger: d})
+	if _, err := s.BreakpointAt("t:1:2", 1, "$", 3, "$x", 2); err != nil {
+		t.Fatalf("BreakpointAt(...) = _,%v, want nil error", err)
+	}
+	if want := map[string]interface{}{"$": 3, "$x": 2}; d.pos != "t:1:2" || d.dot != 1 || !reflect.DeepEqual(d.vars, want) {
+		t.Errorf("BreakpointAt(...) passed %q,%v,%v, want \"t:1:2\",1,%v", d.pos, d.dot, d.vars, want)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	for _, test := range []struct {
+		call      string
+		name      string
+		args      []interface{}
+		want      interface{}
+		wantError bool
+		wantLog   string
+	}{
+		{
+			call:    "getval",
+			name:    "GetVal",
+			args:    []interface{}{map[interface{}]interface{}{"a": 1}, "b"},
+			want:    "",
+			wantLog: `gtpl: trace: a.tpl:1:2: getval map[a:1] "b" = ""`,
+		},
+		{
+			// Numbers are converted to the type of the parameter.
+			call:    ".Gtpl.Loop",
+			name:    "Loop",
+			args:    []interface{}{int64(1), 2.0},
+			wantLog: `gtpl: trace: a.tpl:1:2: .Gtpl.Loop 1 2 = <-chan int`,
+		},
+		{
+			call:    "list",
+			name:    "List",
+			args:    []interface{}{nil, "x"},
+			want:    []interface{}{nil, "x"},
+			wantLog: `gtpl: trace: a.tpl:1:2: list <nil> "x" = [<nil> x]`,
+		},
+		{
+			call:      "die",
+			name:      "DieAt",
+			args:      []interface{}{"dead"},
+			wantError: true,
+			wantLog:   `gtpl: trace: a.tpl:1:2: die "dead" failed: dead`,
+		},
+		{
+			call:      "getval",
+			name:      "GetVal",
+			args:      []interface{}{"a"},
+			wantError: true,
+			wantLog:   `gtpl: trace: a.tpl:1:2: getval "a" failed: getval: wrong number of arguments, want 2, got 1`,
+		},
+		{
+			call:      "loop",
+			name:      "Loop",
+			args:      []interface{}{"a", 2},
+			wantError: true,
+			wantLog:   `gtpl: trace: a.tpl:1:2: loop "a" 2 failed: loop: argument 1: can't use a (string) as int`,
+		},
+		{
+			call:      "nope",
+			name:      "Nope",
+			wantError: true,
+			wantLog:   `gtpl: trace: a.tpl:1:2: nope failed: no such builtin "Nope"`,
+		},
+	} {
+		l := &fakeLogger{}
+		s := New(&Opts{Logger: l, Trace: true})
+		got, err := s.TraceAt("a.tpl:1:2", test.call, test.name, test.args...)
+		if gotErr := err != nil; gotErr != test.wantError {
+			t.Errorf("TraceAt(%q, %q, %v) = _,%v, want error: %v", test.call, test.name, test.args, err, test.wantError)
+		}
+		if _, isChan := got.(<-chan int); err == nil && !isChan && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TraceAt(%q, %q, %v) = %v, want %v", test.call, test.name, test.args, got, test.want)
+		}
+		if want := []string{test.wantLog}; !reflect.DeepEqual(l.lines, want) {
+			t.Errorf("TraceAt(%q, %q, %v) logged %q, want %q", test.call, test.name, test.args, l.lines, want)
+		}
+	}
+
+	l := &fakeLogger{}
+	s := New(&Opts{Logger: l, Trace: true})
+	if got := s.TraceEnter("a.tpl:1:2", "host", 42); got != 42 {
+		t.Errorf("TraceEnter(..., 42) = %v, want 42", got)
+	}
+	if got := s.TraceEnter("a.tpl:1:2", "host"); got != nil {
+		t.Errorf("TraceEnter(...) = %v, want nil", got)
+	}
+	s.TraceExit("a.tpl:1:2", "host")
+
+	// The position isn't logged, but passed to variants that get it.
+	s.TraceAt("a.tpl:1:2", "check", "CheckAt", false, "no port")
+	if got, want := Failures(s), []string{"a.tpl:1:2: check: no port"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TraceAt(..., \"CheckAt\", ...) recorded %q, want %q", got, want)
+	}
+	want := []string{
+		`gtpl: trace: a.tpl:1:2: template "host" with 42`,
+		`gtpl: trace: a.tpl:1:2: template "host"`,
+		`gtpl: trace: a.tpl:1:2: end of template "host"`,
+		`gtpl: trace: a.tpl:1:2: check false "no port" = ""`,
+	}
+	if !reflect.DeepEqual(l.lines, want) {
+		t.Errorf("TraceEnter(...), TraceExit(...) and TraceAt(...) logged %q, want %q", l.lines, want)
+	}
+}
+
+type fakeProfiler struct {
+	events []string
+}
+
+func (f *fakeProfiler) BeginBuiltin(pos, call string) {
+	f.events = append(f.events, "builtin "+call+" at "+pos)
+}
+
+func (f *fakeProfiler) BeginTemplate(pos, name string) {
+	f.events = append(f.events, "template "+name+" at "+pos)
+}
+
+func (f *fakeProfiler) End() {
+	f.events = append(f.events, "end")
+}
+
+func TestProfiler(t *testing.T) {
+	l := &fakeLogger{}
+	f := &fakeProfiler{}
+	s := New(&Opts{Logger: l})
+	Attach(s, Execution{Profiler: f})
+	s.TraceEnter("a.tpl:1:2", "host", 42)
+	if _, err := s.TraceAt("a.tpl:3:4", ".Gtpl.Add", "Add", 1, 2); err != nil {
+		t.Fatalf("TraceAt(...) = _,%v, need nil error", err)
+	}
+	s.TraceExit("a.tpl:1:2", "host")
+	want := []string{"template host at a.tpl:1:2", "builtin .Gtpl.Add at a.tpl:3:4", "end", "end"}
+	if !reflect.DeepEqual(f.events, want) {
+		t.Errorf("TraceEnter(...), TraceAt(...) and TraceExit(...) told the profiler %q, want %q", f.events, want)
+	}
+	if l.lines != nil {
+		t.Errorf("TraceEnter(...), TraceAt(...) and TraceExit(...) without tracing logged %q, want nothing", l.lines)
+	}
+}